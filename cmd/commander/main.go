@@ -33,6 +33,11 @@ var (
 	hostIP         string
 	dns            string
 	shuttleAddr    string
+	registryMirror string
+	indexServer    string
+	dockerTimeout  time.Duration
+	pingTimeout    time.Duration
+	nameTemplate   string
 	debug          bool
 	runOnce        bool
 	version        bool
@@ -52,7 +57,9 @@ func initOrDie() {
 
 	configStore = config.NewStore(config.DefaultTTL, registryURL)
 
-	serviceRuntime = runtime.NewServiceRuntime(configStore, dns, hostIP)
+	serviceRuntime = runtime.NewServiceRuntime(configStore, dns, hostIP, registryMirror, dockerTimeout, pingTimeout)
+	serviceRuntime.ContainerNameTemplate = nameTemplate
+	serviceRuntime.IndexServer = indexServer
 
 	apps, err := configStore.ListAssignments(env, pool)
 	if err != nil {
@@ -157,7 +164,7 @@ func startService(appCfg config.App, logStatus bool) {
 
 		log.Printf("Started %s version %s as %s\n", appCfg.Name(), appCfg.Version(), container.ID[0:12])
 
-		err = serviceRuntime.StopOldVersion(appCfg, 1)
+		_, err = serviceRuntime.StopOldVersion(appCfg, 1)
 		if err != nil {
 			log.Errorf("ERROR: Could not stop containers: %s", err)
 		}
@@ -176,7 +183,7 @@ func startService(appCfg config.App, logStatus bool) {
 		}
 	}
 
-	err = serviceRuntime.StopAllButCurrentVersion(appCfg)
+	_, err = serviceRuntime.StopAllButCurrentVersion(appCfg)
 	if err != nil {
 		log.Errorf("ERROR: Could not stop old containers: %s", err)
 	}
@@ -335,7 +342,7 @@ func restartContainers(app string, cmdChan chan string) {
 	}
 }
 
-func monitorService(changedConfigs chan *config.ConfigChange) {
+func monitorService(changedConfigs <-chan *config.ConfigChange) {
 
 	for {
 
@@ -398,6 +405,11 @@ func main() {
 	flag.StringVar(&hostIP, "host-ip", "127.0.0.1", "Host IP")
 	flag.StringVar(&shuttleAddr, "shuttle-addr", "", "Shuttle API addr (127.0.0.1:9090)")
 	flag.StringVar(&dns, "dns", "", "DNS addr to use for containers")
+	flag.StringVar(&registryMirror, "registry-mirror", utils.GetEnv("GALAXY_REGISTRY_MIRROR", ""), "Registry to pull images without a registry from")
+	flag.StringVar(&indexServer, "index-server", utils.GetEnv("GALAXY_INDEX_SERVER", ""), "Auth config key to use for registries without credentials")
+	flag.DurationVar(&dockerTimeout, "docker-timeout", runtime.DefaultDockerTimeout, "Timeout for docker API calls, including pulls")
+	flag.DurationVar(&pingTimeout, "ping-timeout", runtime.DefaultPingTimeout, "Timeout for docker liveness pings")
+	flag.StringVar(&nameTemplate, "container-name", runtime.DefaultContainerNameTemplate, "Container name template, using .Env, .Pool, .App, .Version, .ContainerName and .Instance")
 	flag.BoolVar(&debug, "debug", false, "verbose logging")
 	flag.BoolVar(&version, "v", false, "display version info")
 
@@ -703,7 +715,9 @@ func main() {
 		return
 
 	case "app:stop":
+		var dryRun bool
 		stopFs := flag.NewFlagSet("app:stop", flag.ExitOnError)
+		stopFs.BoolVar(&dryRun, "dry-run", false, "Only list the containers that would be stopped")
 		stopFs.Usage = func() {
 			println("Usage: commander app:stop [options] [<app>]*\n")
 			println("    Stops one or more apps. If no apps are specified, stops all apps.\n")
@@ -713,6 +727,7 @@ func main() {
 		stopFs.Parse(flag.Args()[1:])
 
 		apps = stopFs.Args()
+		serviceRuntime.DryRun = dryRun
 
 		for _, app := range apps {
 			err := serviceRuntime.StopAllMatching(app)
@@ -724,7 +739,7 @@ func main() {
 			return
 		}
 
-		err := serviceRuntime.StopAll(env)
+		_, err := serviceRuntime.StopAll(env)
 		if err != nil {
 			log.Fatalf("ERROR: Unable able to stop all containers: %s", err)
 		}
@@ -911,20 +926,40 @@ func main() {
 	case "runtime:set":
 		var ps int
 		var m string
+		var swap string
 		var c string
 		var vhost string
 		var port string
 		var maint string
+		var network string
+		var staticIP string
+		var cmd, entrypoint, preStart string
+		var envFile string
+		var stopTimeout int
+		var meta utils.SliceVar
+		var readOnly string
+		var tmpfs utils.SliceVar
 		runtimeFs := flag.NewFlagSet("runtime:set", flag.ExitOnError)
 		runtimeFs.IntVar(&ps, "ps", 0, "Number of instances to run across all hosts")
 		runtimeFs.StringVar(&m, "m", "", "Memory limit (format: <number><optional unit>, where unit = b, k, m or g)")
+		runtimeFs.StringVar(&swap, "memory-swap", "", "Total memory limit, memory + swap (same format as -m)")
 		runtimeFs.StringVar(&c, "c", "", "CPU shares (relative weight)")
 		runtimeFs.StringVar(&vhost, "vhost", "", "Virtual host for HTTP routing")
 		runtimeFs.StringVar(&port, "port", "", "Service port for service discovery")
 		runtimeFs.StringVar(&maint, "maint", "", "Enable or disable maintenance mode")
+		runtimeFs.StringVar(&network, "net", "", "Docker network (host, none, bridge or a user-defined network)")
+		runtimeFs.StringVar(&staticIP, "static-ip", "", "IPv4 address for the containers on their user-defined network")
+		runtimeFs.StringVar(&cmd, "cmd", "", "Command to run instead of the image's CMD (space separated, or a JSON array)")
+		runtimeFs.StringVar(&entrypoint, "entrypoint", "", "Entrypoint to use instead of the image's ENTRYPOINT (space separated, or a JSON array)")
+		runtimeFs.StringVar(&preStart, "pre-start", "", "Command to run in a one-off container before starting, e.g. migrations (space separated, or a JSON array)")
+		runtimeFs.StringVar(&envFile, "env-file", "", "Env file on the host to read secrets from when starting containers")
+		runtimeFs.IntVar(&stopTimeout, "stop-timeout", 0, "Seconds to wait for a container to stop before killing it")
+		runtimeFs.Var(&meta, "meta", "Service registration metadata as key=value (can be passed multiple times)")
+		runtimeFs.StringVar(&readOnly, "read-only", "", "Enable or disable a read-only root filesystem")
+		runtimeFs.Var(&tmpfs, "tmpfs", "tmpfs mount as path[:options], e.g. /tmp:rw,size=64m (can be passed multiple times)")
 
 		runtimeFs.Usage = func() {
-			println("Usage: commander runtime:set [-ps 1] [-m 100m] [-c 512] [-vhost x.y.z] [-port 8000] [-maint false] <app>\n")
+			println("Usage: commander runtime:set [-ps 1] [-m 100m] [-memory-swap 200m] [-c 512] [-vhost x.y.z] [-port 8000] [-maint false] [-net name] [-static-ip 10.1.0.5] [-cmd 'worker -v'] [-entrypoint /init] [-pre-start 'manage migrate'] [-env-file /etc/app.env] [-stop-timeout 10] [-meta role=api] [-read-only true] [-tmpfs /tmp] <app>\n")
 			println("    Set container runtime policies\n")
 			println("Options:\n")
 			runtimeFs.PrintDefaults()
@@ -937,7 +972,7 @@ func main() {
 
 		ensureEnv()
 
-		if ps != 0 || m != "" || c != "" || maint != "" {
+		if ps != 0 || m != "" || swap != "" || c != "" || maint != "" || network != "" || staticIP != "" || cmd != "" || entrypoint != "" || preStart != "" || envFile != "" || stopTimeout != 0 || len(meta) > 0 || readOnly != "" || len(tmpfs) > 0 {
 			ensurePool()
 		}
 
@@ -953,13 +988,29 @@ func main() {
 			log.Fatalf("ERROR: Bad memory option %s: %s", m, err)
 		}
 
+		_, err = utils.ParseMemory(swap)
+		if err != nil {
+			log.Fatalf("ERROR: Bad memory swap option %s: %s", swap, err)
+		}
+
 		updated, err := commander.RuntimeSet(configStore, app, env, pool, commander.RuntimeOptions{
 			Ps:              ps,
 			Memory:          m,
+			MemorySwap:      swap,
 			CPUShares:       c,
 			VirtualHost:     vhost,
 			Port:            port,
 			MaintenanceMode: maint,
+			Network:         network,
+			StaticIP:        staticIP,
+			Command:         cmd,
+			Entrypoint:      entrypoint,
+			PreStart:        preStart,
+			EnvFile:         envFile,
+			StopTimeout:     stopTimeout,
+			ServiceMeta:     meta,
+			ReadOnlyRootfs:  readOnly,
+			Tmpfs:           tmpfs,
 		})
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
@@ -977,17 +1028,29 @@ func main() {
 		return
 
 	case "runtime:unset":
-		var ps, m, c, port bool
+		var ps, m, swap, c, port, network, staticIP, cmd, entrypoint, preStart, envFile, stopTimeout, readOnly bool
 		var vhost string
+		var meta, tmpfs utils.SliceVar
 		runtimeFs := flag.NewFlagSet("runtime:unset", flag.ExitOnError)
 		runtimeFs.BoolVar(&ps, "ps", false, "Number of instances to run across all hosts")
 		runtimeFs.BoolVar(&m, "m", false, "Memory limit")
+		runtimeFs.BoolVar(&swap, "memory-swap", false, "Memory + swap limit")
 		runtimeFs.BoolVar(&c, "c", false, "CPU shares (relative weight)")
 		runtimeFs.StringVar(&vhost, "vhost", "", "Virtual host for HTTP routing")
 		runtimeFs.BoolVar(&port, "port", false, "Service port for service discovery")
+		runtimeFs.BoolVar(&network, "net", false, "Docker network")
+		runtimeFs.BoolVar(&staticIP, "static-ip", false, "Static IP on the network")
+		runtimeFs.BoolVar(&cmd, "cmd", false, "Command override")
+		runtimeFs.BoolVar(&entrypoint, "entrypoint", false, "Entrypoint override")
+		runtimeFs.BoolVar(&preStart, "pre-start", false, "Pre-start hook")
+		runtimeFs.BoolVar(&envFile, "env-file", false, "Env file")
+		runtimeFs.BoolVar(&stopTimeout, "stop-timeout", false, "Stop timeout")
+		runtimeFs.Var(&meta, "meta", "Service registration metadata key (can be passed multiple times)")
+		runtimeFs.BoolVar(&readOnly, "read-only", false, "Read-only root filesystem")
+		runtimeFs.Var(&tmpfs, "tmpfs", "tmpfs mount path (can be passed multiple times)")
 
 		runtimeFs.Usage = func() {
-			println("Usage: commander runtime:unset [-ps] [-m] [-c] [-vhost x.y.z] [-port] <app>\n")
+			println("Usage: commander runtime:unset [-ps] [-m] [-memory-swap] [-c] [-vhost x.y.z] [-port] [-net] [-static-ip] [-cmd] [-entrypoint] [-pre-start] [-env-file] [-stop-timeout] [-meta role] [-read-only] [-tmpfs /tmp] <app>\n")
 			println("    Reset and removes container runtime policies to defaults\n")
 			println("Options:\n")
 			runtimeFs.PrintDefaults()
@@ -1000,7 +1063,7 @@ func main() {
 
 		ensureEnv()
 
-		if ps || m || c {
+		if ps || m || swap || c || network || staticIP || cmd || entrypoint || preStart || envFile || stopTimeout || len(meta) > 0 || readOnly || len(tmpfs) > 0 {
 			ensurePool()
 		}
 
@@ -1013,7 +1076,13 @@ func main() {
 
 		options := commander.RuntimeOptions{
 			VirtualHost: vhost,
+			ServiceMeta: meta,
+			Tmpfs:       tmpfs,
 		}
+		if readOnly {
+			options.ReadOnlyRootfs = "-"
+		}
+
 		if ps {
 			options.Ps = -1
 		}
@@ -1022,6 +1091,10 @@ func main() {
 			options.Memory = "-"
 		}
 
+		if swap {
+			options.MemorySwap = "-"
+		}
+
 		if c {
 			options.CPUShares = "-"
 		}
@@ -1030,6 +1103,34 @@ func main() {
 			options.Port = "-"
 		}
 
+		if network {
+			options.Network = "-"
+		}
+
+		if staticIP {
+			options.StaticIP = "-"
+		}
+
+		if cmd {
+			options.Command = "-"
+		}
+
+		if entrypoint {
+			options.Entrypoint = "-"
+		}
+
+		if preStart {
+			options.PreStart = "-"
+		}
+
+		if envFile {
+			options.EnvFile = "-"
+		}
+
+		if stopTimeout {
+			options.StopTimeout = -1
+		}
+
 		updated, err := commander.RuntimeUnset(configStore, app, env, pool, options)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
@@ -1136,10 +1237,9 @@ func main() {
 		go heartbeatHost()
 
 		go discovery.Register(serviceRuntime, configStore, env, pool, hostIP, shuttleAddr)
-		cancelChan := make(chan struct{})
 		// do we need to cancel ever?
-
-		restartChan := configStore.Watch(env, cancelChan)
+		restartChan, cancel := configStore.Watch(env, pool)
+		defer cancel()
 		monitorService(restartChan)
 	}
 
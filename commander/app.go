@@ -2,6 +2,8 @@ package commander
 
 import (
 	"fmt"
+	"os"
+	"os/user"
 	"strconv"
 	"strings"
 
@@ -129,7 +131,7 @@ func AppDeploy(configStore *config.Store, serviceRuntime *runtime.ServiceRuntime
 		return fmt.Errorf("app %s does not exist. Create it first.", app)
 	}
 
-	svcCfg.SetVersion(version)
+	svcCfg.Deploy(version, deployActor())
 	svcCfg.SetVersionID(image.ID)
 
 	updated, err := configStore.UpdateApp(svcCfg, env)
@@ -143,6 +145,19 @@ func AppDeploy(configStore *config.Store, serviceRuntime *runtime.ServiceRuntime
 	return nil
 }
 
+// deployActor names who's deploying, as user@host
+func deployActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
+
 func AppRestart(Store *config.Store, app, env string) error {
 	err := Store.NotifyRestart(app, env)
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/litl/galaxy/config"
 	"github.com/litl/galaxy/log"
+	"github.com/litl/galaxy/utils"
 )
 
 func ConfigList(configStore *config.Store, app, env string) error {
@@ -55,16 +56,7 @@ func ConfigSet(configStore *config.Store, app, env string, envVars []string) err
 		return fmt.Errorf("no config values specified.")
 	}
 
-	svcCfg, err := configStore.GetApp(app, env)
-	if err != nil {
-		return fmt.Errorf("unable to set config: %s.", err)
-	}
-
-	if svcCfg == nil {
-		svcCfg = configStore.NewAppConfig(app, "")
-	}
-
-	updated := false
+	vars := make(map[string]string)
 	for _, arg := range envVars {
 
 		if strings.TrimSpace(arg) == "" {
@@ -84,23 +76,26 @@ func ConfigSet(configStore *config.Store, app, env string, envVars []string) err
 		}
 
 		log.Printf("%s=%s\n", k, v)
-		svcCfg.EnvSet(k, v)
-		updated = true
+		vars[k] = v
 	}
 
-	if !updated {
+	if len(vars) == 0 {
 		return fmt.Errorf("configuration NOT changed for %s", app)
 	}
 
-	updated, err = configStore.UpdateApp(svcCfg, env)
+	var version int64
+	err := configStore.ModifyApp(app, env, func(svcCfg config.App) error {
+		for k, v := range vars {
+			svcCfg.EnvSet(k, v)
+		}
+		version = svcCfg.ID()
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("unable to set config: %s.", err)
 	}
 
-	if !updated {
-		return fmt.Errorf("configuration NOT changed for %s", app)
-	}
-	log.Printf("Configuration changed for %s. v%d\n", app, svcCfg.ID())
+	log.Printf("Configuration changed for %s. v%d\n", app, version)
 	return nil
 }
 
@@ -123,38 +118,47 @@ func ConfigUnset(configStore *config.Store, app, env string, envVars []string) e
 		return fmt.Errorf("no config values specified.")
 	}
 
-	svcCfg, err := configStore.GetApp(app, env)
-	if err != nil {
-		return fmt.Errorf("unable to unset config: %s.", err)
+	notChanged := fmt.Errorf("Configuration NOT changed for %s", app)
+
+	var unset []string
+	var version int64
+	err := configStore.ModifyApp(app, env, func(svcCfg config.App) error {
+		unset = nil
+		for _, arg := range envVars {
+			k := strings.ToUpper(strings.TrimSpace(arg))
+			if k == "ENV" || svcCfg.EnvGet(k) == "" {
+				continue
+			}
+
+			svcCfg.EnvSet(k, "")
+			unset = append(unset, k)
+		}
+
+		if len(unset) == 0 {
+			return notChanged
+		}
+		version = svcCfg.ID()
+		return nil
+	})
+
+	if err != nil && err != notChanged {
+		return fmt.Errorf("ERROR: Unable to unset config: %s.", err)
 	}
 
-	updated := false
 	for _, arg := range envVars {
 		k := strings.ToUpper(strings.TrimSpace(arg))
-		if k == "ENV" || svcCfg.EnvGet(k) == "" {
+		if !utils.StringInSlice(k, unset) {
 			log.Warnf("%s cannot be unset.", k)
-			continue
 		}
-
-		log.Printf("%s\n", k)
-		svcCfg.EnvSet(strings.ToUpper(arg), "")
-		updated = true
-	}
-
-	if !updated {
-		return fmt.Errorf("Configuration NOT changed for %s", app)
 	}
 
-	updated, err = configStore.UpdateApp(svcCfg, env)
-	if err != nil {
-		return fmt.Errorf("ERROR: Unable to unset config: %s.", err)
-
+	if err == notChanged {
+		return err
 	}
 
-	if !updated {
-		return fmt.Errorf("Configuration NOT changed for %s", app)
-
+	for _, k := range unset {
+		log.Printf("%s\n", k)
 	}
-	log.Printf("Configuration changed for %s. v%d.\n", app, svcCfg.ID())
+	log.Printf("Configuration changed for %s. v%d.\n", app, version)
 	return nil
 }
@@ -1,7 +1,9 @@
 package commander
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -13,10 +15,38 @@ import (
 type RuntimeOptions struct {
 	Ps              int
 	Memory          string
+	MemorySwap      string
 	CPUShares       string
 	VirtualHost     string
 	Port            string
 	MaintenanceMode string
+	Network         string
+	StaticIP        string
+	Command         string
+	Entrypoint      string
+	PreStart        string
+	EnvFile         string
+	StopTimeout     int
+	// key=value pairs to set, or keys to unset
+	ServiceMeta    []string
+	ReadOnlyRootfs string
+	// path[:options] mounts to set, or paths to unset
+	Tmpfs []string
+}
+
+// ParseCommand splits a command given on the command line into its args. A
+// JSON array is used as-is, as with a Dockerfile's CMD, otherwise the command
+// is split on whitespace.
+func ParseCommand(cmd string) ([]string, error) {
+	cmd = strings.TrimSpace(cmd)
+	if strings.HasPrefix(cmd, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(cmd), &args); err != nil {
+			return nil, fmt.Errorf("bad command %s: %s", cmd, err)
+		}
+		return args, nil
+	}
+	return strings.Fields(cmd), nil
 }
 
 func RuntimeList(configStore *config.Store, app, env, pool string) error {
@@ -90,6 +120,10 @@ func RuntimeSet(configStore *config.Store, app, env, pool string, options Runtim
 		cfg.SetMemory(pool, options.Memory)
 	}
 
+	if options.MemorySwap != "" && options.MemorySwap != cfg.GetMemorySwap(pool) {
+		cfg.SetMemorySwap(pool, options.MemorySwap)
+	}
+
 	vhosts := []string{}
 	vhostsFromEnv := cfg.Env()["VIRTUAL_HOST"]
 	if vhostsFromEnv != "" {
@@ -114,6 +148,87 @@ func RuntimeSet(configStore *config.Store, app, env, pool string, options Runtim
 		cfg.SetMaintenanceMode(pool, b)
 	}
 
+	if options.Network != "" && options.Network != cfg.GetNetwork(pool) {
+		cfg.SetNetwork(pool, options.Network)
+	}
+
+	if options.StaticIP != "" {
+		if ip := net.ParseIP(options.StaticIP); ip == nil || ip.To4() == nil {
+			return false, fmt.Errorf("bad static IP %q, want an IPv4 address", options.StaticIP)
+		}
+		cfg.SetStaticIP(pool, options.StaticIP)
+	}
+
+	if options.Command != "" {
+		cmd, err := ParseCommand(options.Command)
+		if err != nil {
+			return false, err
+		}
+		cfg.SetCommand(pool, cmd)
+	}
+
+	if options.Entrypoint != "" {
+		entrypoint, err := ParseCommand(options.Entrypoint)
+		if err != nil {
+			return false, err
+		}
+		cfg.SetEntrypoint(pool, entrypoint)
+	}
+
+	if options.PreStart != "" {
+		hook, err := ParseCommand(options.PreStart)
+		if err != nil {
+			return false, err
+		}
+		cfg.SetPreStart(pool, hook)
+	}
+
+	if options.EnvFile != "" && options.EnvFile != cfg.GetEnvFile(pool) {
+		cfg.SetEnvFile(pool, options.EnvFile)
+	}
+
+	if options.StopTimeout > 0 && options.StopTimeout != cfg.GetStopTimeout(pool) {
+		cfg.SetStopTimeout(pool, options.StopTimeout)
+	}
+
+	if len(options.ServiceMeta) > 0 {
+		meta := cfg.GetServiceMeta(pool)
+		for _, kv := range options.ServiceMeta {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return false, fmt.Errorf("bad service metadata %q, want key=value", kv)
+			}
+			meta[parts[0]] = parts[1]
+		}
+		cfg.SetServiceMeta(pool, meta)
+	}
+
+	if options.ReadOnlyRootfs != "" {
+		b, err := strconv.ParseBool(options.ReadOnlyRootfs)
+		if err != nil {
+			return false, err
+		}
+
+		cfg.SetReadOnlyRootfs(pool, b)
+	}
+
+	if len(options.Tmpfs) > 0 {
+		mounts := cfg.GetTmpfs(pool)
+		for _, mount := range options.Tmpfs {
+			parts := strings.SplitN(mount, ":", 2)
+			if !strings.HasPrefix(parts[0], "/") {
+				return false, fmt.Errorf("bad tmpfs mount %q, want an absolute path[:options]", mount)
+			}
+
+			opts := ""
+			if len(parts) == 2 {
+				opts = parts[1]
+			}
+			mounts[parts[0]] = opts
+		}
+		cfg.SetTmpfs(pool, mounts)
+	}
+
 	return configStore.UpdateApp(cfg, env)
 }
 
@@ -132,6 +247,10 @@ func RuntimeUnset(configStore *config.Store, app, env, pool string, options Runt
 		cfg.SetMemory(pool, "")
 	}
 
+	if options.MemorySwap != "" {
+		cfg.SetMemorySwap(pool, "")
+	}
+
 	vhosts := strings.Split(cfg.Env()["VIRTUAL_HOST"], ",")
 	if options.VirtualHost != "" && utils.StringInSlice(options.VirtualHost, vhosts) {
 		vhosts = utils.RemoveStringInSlice(options.VirtualHost, vhosts)
@@ -142,5 +261,53 @@ func RuntimeUnset(configStore *config.Store, app, env, pool string, options Runt
 		cfg.EnvSet("GALAXY_PORT", "")
 	}
 
+	if options.Network != "" {
+		cfg.SetNetwork(pool, "")
+	}
+
+	if options.StaticIP != "" {
+		cfg.SetStaticIP(pool, "")
+	}
+
+	if options.Command != "" {
+		cfg.SetCommand(pool, nil)
+	}
+
+	if options.Entrypoint != "" {
+		cfg.SetEntrypoint(pool, nil)
+	}
+
+	if options.PreStart != "" {
+		cfg.SetPreStart(pool, nil)
+	}
+
+	if options.EnvFile != "" {
+		cfg.SetEnvFile(pool, "")
+	}
+
+	if options.StopTimeout != 0 {
+		cfg.SetStopTimeout(pool, 0)
+	}
+
+	if len(options.ServiceMeta) > 0 {
+		meta := cfg.GetServiceMeta(pool)
+		for _, key := range options.ServiceMeta {
+			delete(meta, key)
+		}
+		cfg.SetServiceMeta(pool, meta)
+	}
+
+	if options.ReadOnlyRootfs != "" {
+		cfg.SetReadOnlyRootfs(pool, false)
+	}
+
+	if len(options.Tmpfs) > 0 {
+		mounts := cfg.GetTmpfs(pool)
+		for _, path := range options.Tmpfs {
+			delete(mounts, path)
+		}
+		cfg.SetTmpfs(pool, mounts)
+	}
+
 	return configStore.UpdateApp(cfg, env)
 }
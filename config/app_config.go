@@ -1,9 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/litl/galaxy/utils"
 )
@@ -17,6 +19,8 @@ type App interface {
 	EnvGet(key string) string
 	Version() string
 	SetVersion(version string)
+	Deploy(version, actor string)
+	LastDeploy() (string, time.Time, string)
 	VersionID() string
 	SetVersionID(versionID string)
 	ID() int64
@@ -26,10 +30,32 @@ type App interface {
 	RuntimePools() []string
 	SetMemory(pool string, mem string)
 	GetMemory(pool string) string
+	SetMemorySwap(pool string, swap string)
+	GetMemorySwap(pool string) string
 	SetCPUShares(pool string, cpu string)
 	GetCPUShares(pool string) string
 	SetMaintenanceMode(pool string, maint bool)
 	GetMaintenanceMode(pool string) bool
+	SetNetwork(pool string, network string)
+	GetNetwork(pool string) string
+	SetStaticIP(pool string, ip string)
+	GetStaticIP(pool string) string
+	SetCommand(pool string, cmd []string)
+	GetCommand(pool string) []string
+	SetEntrypoint(pool string, entrypoint []string)
+	GetEntrypoint(pool string) []string
+	SetPreStart(pool string, cmd []string)
+	GetPreStart(pool string) []string
+	SetEnvFile(pool string, path string)
+	GetEnvFile(pool string) string
+	SetStopTimeout(pool string, seconds int)
+	GetStopTimeout(pool string) int
+	SetServiceMeta(pool string, meta map[string]string)
+	GetServiceMeta(pool string) map[string]string
+	SetReadOnlyRootfs(pool string, readOnly bool)
+	GetReadOnlyRootfs(pool string) bool
+	SetTmpfs(pool string, mounts map[string]string)
+	GetTmpfs(pool string) map[string]string
 }
 
 type AppConfig struct {
@@ -99,6 +125,23 @@ func (s *AppConfig) SetVersion(version string) {
 	s.versionVMap.SetVersion("version", version, s.nextID())
 }
 
+// Deploy sets the version, recording when it was deployed and by whom. The
+// version and deploy metadata share a config version, so they merge as one
+// change.
+func (s *AppConfig) Deploy(version, actor string) {
+	id := s.nextID()
+	s.versionVMap.SetVersion("version", version, id)
+	s.versionVMap.SetVersion("deployedAt", time.Now().UTC().Format(time.RFC3339Nano), id)
+	s.versionVMap.SetVersion("deployedBy", actor, id)
+}
+
+// LastDeploy returns the current version, and when and by whom the last
+// Deploy was. The time is zero if the app was never deployed with Deploy.
+func (s *AppConfig) LastDeploy() (string, time.Time, string) {
+	at, _ := time.Parse(time.RFC3339Nano, s.versionVMap.Get("deployedAt"))
+	return s.Version(), at, s.versionVMap.Get("deployedBy")
+}
+
 func (s *AppConfig) VersionID() string {
 	return s.versionVMap.Get("versionID")
 }
@@ -124,8 +167,21 @@ func (s *AppConfig) ClearPorts() {
 	}
 }
 
-func (s *AppConfig) AddPort(port, portType string) {
-	s.portsVMap.Set(port, portType)
+// AddPort adds a port exposed by the app. The port must be a number from 1 to
+// 65535, and portType either tcp or udp.
+func (s *AppConfig) AddPort(port, portType string) error {
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	switch portType {
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid protocol %q for port %s", portType, port)
+	}
+
+	s.portsVMap.SetVersion(port, portType, s.nextID())
+	return nil
 }
 
 func (s *AppConfig) ID() int64 {
@@ -188,6 +244,16 @@ func (s *AppConfig) GetMemory(pool string) string {
 	return s.runtimeVMap.Get(key)
 }
 
+func (s *AppConfig) SetMemorySwap(pool string, swap string) {
+	key := fmt.Sprintf("%s-memswap", pool)
+	s.runtimeVMap.SetVersion(key, swap, s.nextID())
+}
+
+func (s *AppConfig) GetMemorySwap(pool string) string {
+	key := fmt.Sprintf("%s-memswap", pool)
+	return s.runtimeVMap.Get(key)
+}
+
 func (s *AppConfig) SetCPUShares(pool string, cpu string) {
 	key := fmt.Sprintf("%s-cpu", pool)
 	s.runtimeVMap.SetVersion(key, cpu, s.nextID())
@@ -208,3 +274,145 @@ func (s *AppConfig) GetMaintenanceMode(pool string) bool {
 	maint, _ := strconv.ParseBool(s.runtimeVMap.Get(key))
 	return maint
 }
+
+func (s *AppConfig) SetNetwork(pool string, network string) {
+	key := fmt.Sprintf("%s-network", pool)
+	s.runtimeVMap.SetVersion(key, network, s.nextID())
+}
+
+func (s *AppConfig) GetNetwork(pool string) string {
+	key := fmt.Sprintf("%s-network", pool)
+	return s.runtimeVMap.Get(key)
+}
+
+func (s *AppConfig) SetStaticIP(pool string, ip string) {
+	key := fmt.Sprintf("%s-staticip", pool)
+	s.runtimeVMap.SetVersion(key, ip, s.nextID())
+}
+
+func (s *AppConfig) GetStaticIP(pool string) string {
+	key := fmt.Sprintf("%s-staticip", pool)
+	return s.runtimeVMap.Get(key)
+}
+
+// store a list in the runtime map as a JSON array. An empty list is stored as
+// an empty value, so that it reads back as nil.
+func (s *AppConfig) setList(key string, list []string) {
+	value := ""
+	if len(list) > 0 {
+		b, _ := json.Marshal(list)
+		value = string(b)
+	}
+	s.runtimeVMap.SetVersion(key, value, s.nextID())
+}
+
+func (s *AppConfig) getList(key string) []string {
+	var list []string
+	value := s.runtimeVMap.Get(key)
+	if value == "" {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return nil
+	}
+	return list
+}
+
+func (s *AppConfig) SetCommand(pool string, cmd []string) {
+	s.setList(fmt.Sprintf("%s-cmd", pool), cmd)
+}
+
+func (s *AppConfig) GetCommand(pool string) []string {
+	return s.getList(fmt.Sprintf("%s-cmd", pool))
+}
+
+func (s *AppConfig) SetEntrypoint(pool string, entrypoint []string) {
+	s.setList(fmt.Sprintf("%s-entrypoint", pool), entrypoint)
+}
+
+func (s *AppConfig) GetEntrypoint(pool string) []string {
+	return s.getList(fmt.Sprintf("%s-entrypoint", pool))
+}
+
+func (s *AppConfig) SetPreStart(pool string, cmd []string) {
+	s.setList(fmt.Sprintf("%s-prestart", pool), cmd)
+}
+
+func (s *AppConfig) GetPreStart(pool string) []string {
+	return s.getList(fmt.Sprintf("%s-prestart", pool))
+}
+
+func (s *AppConfig) SetEnvFile(pool string, path string) {
+	key := fmt.Sprintf("%s-envfile", pool)
+	s.runtimeVMap.SetVersion(key, path, s.nextID())
+}
+
+func (s *AppConfig) GetEnvFile(pool string) string {
+	key := fmt.Sprintf("%s-envfile", pool)
+	return s.runtimeVMap.Get(key)
+}
+
+func (s *AppConfig) SetStopTimeout(pool string, seconds int) {
+	key := fmt.Sprintf("%s-stoptimeout", pool)
+	s.runtimeVMap.SetVersion(key, strconv.Itoa(seconds), s.nextID())
+}
+
+func (s *AppConfig) GetStopTimeout(pool string) int {
+	key := fmt.Sprintf("%s-stoptimeout", pool)
+	seconds, _ := strconv.Atoi(s.runtimeVMap.Get(key))
+	return seconds
+}
+
+func (s *AppConfig) SetServiceMeta(pool string, meta map[string]string) {
+	key := fmt.Sprintf("%s-meta", pool)
+	value := ""
+	if len(meta) > 0 {
+		b, _ := json.Marshal(meta)
+		value = string(b)
+	}
+	s.runtimeVMap.SetVersion(key, value, s.nextID())
+}
+
+func (s *AppConfig) GetServiceMeta(pool string) map[string]string {
+	meta := map[string]string{}
+	value := s.runtimeVMap.Get(fmt.Sprintf("%s-meta", pool))
+	if value == "" {
+		return meta
+	}
+
+	json.Unmarshal([]byte(value), &meta)
+	return meta
+}
+
+func (s *AppConfig) SetReadOnlyRootfs(pool string, readOnly bool) {
+	key := fmt.Sprintf("%s-readonly", pool)
+	s.runtimeVMap.SetVersion(key, fmt.Sprint(readOnly), s.nextID())
+}
+
+func (s *AppConfig) GetReadOnlyRootfs(pool string) bool {
+	key := fmt.Sprintf("%s-readonly", pool)
+	readOnly, _ := strconv.ParseBool(s.runtimeVMap.Get(key))
+	return readOnly
+}
+
+func (s *AppConfig) SetTmpfs(pool string, mounts map[string]string) {
+	key := fmt.Sprintf("%s-tmpfs", pool)
+	value := ""
+	if len(mounts) > 0 {
+		b, _ := json.Marshal(mounts)
+		value = string(b)
+	}
+	s.runtimeVMap.SetVersion(key, value, s.nextID())
+}
+
+func (s *AppConfig) GetTmpfs(pool string) map[string]string {
+	mounts := map[string]string{}
+	value := s.runtimeVMap.Get(fmt.Sprintf("%s-tmpfs", pool))
+	if value == "" {
+		return mounts
+	}
+
+	json.Unmarshal([]byte(value), &mounts)
+	return mounts
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -55,6 +56,77 @@ func TestSetEnv(t *testing.T) {
 	}
 }
 
+func TestSetCommand(t *testing.T) {
+	sc := NewAppConfig("foo", "")
+	if sc.GetCommand("web") != nil || sc.GetEntrypoint("web") != nil {
+		t.Fail()
+	}
+
+	cmd := []string{"worker", "--queue", "default queue"}
+	sc.SetCommand("web", cmd)
+	if !reflect.DeepEqual(sc.GetCommand("web"), cmd) {
+		t.Fail()
+	}
+	if sc.GetCommand("batch") != nil {
+		t.Fail()
+	}
+
+	sc.SetEntrypoint("web", []string{"/init"})
+	if !reflect.DeepEqual(sc.GetEntrypoint("web"), []string{"/init"}) {
+		t.Fail()
+	}
+
+	sc.SetCommand("web", nil)
+	if sc.GetCommand("web") != nil {
+		t.Fail()
+	}
+}
+
+func TestAddPort(t *testing.T) {
+	sc := NewAppConfig("foo", "").(*AppConfig)
+	for port, proto := range map[string]string{"8080": "tcp", "53": "udp", "65535": "tcp"} {
+		if err := sc.AddPort(port, proto); err != nil {
+			t.Errorf("AddPort(%q, %q) = %v, want %v", port, proto, err, nil)
+		}
+	}
+
+	// each port is a new config version, like the other versioned fields
+	expected := NewAppConfig("foo", "").(*AppConfig)
+	id := expected.ID() + 3
+	if sc.ID() != id {
+		t.Errorf("ID() = %d, want %d", sc.ID(), id)
+	}
+
+	want := map[string]string{"8080": "tcp", "53": "udp", "65535": "tcp"}
+	if !reflect.DeepEqual(sc.Ports(), want) {
+		t.Errorf("Ports() = %v, want %v", sc.Ports(), want)
+	}
+}
+
+func TestAddPortInvalid(t *testing.T) {
+	sc := NewAppConfig("foo", "").(*AppConfig)
+	id := sc.ID()
+
+	for _, p := range [][2]string{
+		{"8080", "tpc"},
+		{"8080", ""},
+		{"8080", "TCP"},
+		{"0", "tcp"},
+		{"65536", "tcp"},
+		{"-1", "tcp"},
+		{"http", "tcp"},
+		{"", "tcp"},
+	} {
+		if err := sc.AddPort(p[0], p[1]); err == nil {
+			t.Errorf("AddPort(%q, %q) = %v, want an error", p[0], p[1], err)
+		}
+	}
+
+	if len(sc.Ports()) != 0 || sc.ID() != id {
+		t.Errorf("rejected ports changed the config: %v, ID %d, want ID %d", sc.Ports(), sc.ID(), id)
+	}
+}
+
 func TestID(t *testing.T) {
 	sc := NewAppConfig("foo", "")
 	id := sc.ID()
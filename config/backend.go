@@ -7,6 +7,7 @@ type Backend interface {
 	ListApps(env string) ([]App, error)
 	GetApp(app, env string) (App, error)
 	UpdateApp(svcCfg App, env string) (bool, error)
+	CompareAndSwapApp(svcCfg App, env string, prevID int64) (bool, error)
 	DeleteApp(svcCfg App, env string) (bool, error)
 
 	// Pools
@@ -26,7 +27,7 @@ type Backend interface {
 	DeleteHost(env, pool string, host HostInfo) error
 
 	//Pub/Sub
-	Subscribe(key string) chan string
+	Subscribe(key string, stop chan struct{}) chan string
 	Notify(key, value string) (int, error)
 
 	// Registration
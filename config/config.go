@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 // AppDefintiion contains all the configuration needed to run a container
@@ -19,6 +20,10 @@ type AppDefinition struct {
 	// Image is the specific docker image to be run.
 	Image string
 
+	// When the Image was last deployed, and by whom
+	DeployedAt time.Time
+	DeployedBy string
+
 	// Docker Image ID
 	// If "Image" does not contain a tag, or uses "latest", we need a way to
 	// know what version we're running.
@@ -107,6 +112,44 @@ type AppAssignment struct {
 
 	// Whether this app is in maintenance mode
 	MaintenanceMode bool
+
+	// Docker network to run the app's containers on: host, none, bridge or
+	// the name of a user-defined network. The default bridge if empty.
+	Network string
+
+	// IPv4 address to give the app's containers on its user-defined
+	// Network, e.g. for firewall allow-lists. Docker assigns one if empty.
+	StaticIP string
+
+	// Command and Entrypoint override the image's CMD and ENTRYPOINT when
+	// set.
+	Command    []string
+	Entrypoint []string
+
+	// One-off command run in a throwaway container before each start, e.g.
+	// a migration. The app isn't started if it exits non-zero.
+	PreStart []string
+
+	// Path to an env file on the host, read when a container is started.
+	// Its values are never stored in the config, and the app's Environment
+	// takes precedence over them.
+	EnvFile string
+
+	// Seconds docker waits after SIGTERM before killing a container.
+	// The runtime default is used if 0.
+	StopTimeout int
+
+	// Arbitrary metadata included in the app's service registrations, for
+	// service discovery consumers.
+	ServiceMeta map[string]string
+
+	// Run with a read-only root filesystem. The app can only write to its
+	// Tmpfs mounts and the image's VOLUMEs.
+	ReadOnlyRootfs bool
+
+	// tmpfs mounts, from the container path to the mount options, e.g.
+	// "/tmp": "rw,size=64m". The options may be empty.
+	Tmpfs map[string]string
 }
 
 //
@@ -136,6 +179,16 @@ func (a *AppDefinition) SetVersion(version string) {
 	a.Image = version
 }
 
+func (a *AppDefinition) Deploy(version, actor string) {
+	a.Image = version
+	a.DeployedAt = time.Now().UTC()
+	a.DeployedBy = actor
+}
+
+func (a *AppDefinition) LastDeploy() (string, time.Time, string) {
+	return a.Image, a.DeployedAt, a.DeployedBy
+}
+
 func (a *AppDefinition) VersionID() string {
 	return a.ImageID
 }
@@ -180,6 +233,16 @@ func (a *AppDefinition) GetMemory(pool string) string {
 	return a.Assignments[i].Memory
 }
 
+func (a *AppDefinition) SetMemorySwap(pool string, swap string) {
+	i := a.assignment(pool)
+	a.Assignments[i].MemorySwap = swap
+}
+
+func (a *AppDefinition) GetMemorySwap(pool string) string {
+	i := a.assignment(pool)
+	return a.Assignments[i].MemorySwap
+}
+
 func (a *AppDefinition) SetCPUShares(pool string, cpu string) {
 	i := a.assignment(pool)
 	a.Assignments[i].CPU, _ = strconv.Atoi(cpu)
@@ -200,6 +263,114 @@ func (a *AppDefinition) GetMaintenanceMode(pool string) bool {
 	return a.Assignments[i].MaintenanceMode
 }
 
+func (a *AppDefinition) SetNetwork(pool string, network string) {
+	i := a.assignment(pool)
+	a.Assignments[i].Network = network
+}
+
+func (a *AppDefinition) GetNetwork(pool string) string {
+	i := a.assignment(pool)
+	return a.Assignments[i].Network
+}
+
+func (a *AppDefinition) SetStaticIP(pool string, ip string) {
+	i := a.assignment(pool)
+	a.Assignments[i].StaticIP = ip
+}
+
+func (a *AppDefinition) GetStaticIP(pool string) string {
+	i := a.assignment(pool)
+	return a.Assignments[i].StaticIP
+}
+
+func (a *AppDefinition) SetCommand(pool string, cmd []string) {
+	i := a.assignment(pool)
+	a.Assignments[i].Command = cmd
+}
+
+func (a *AppDefinition) GetCommand(pool string) []string {
+	i := a.assignment(pool)
+	return a.Assignments[i].Command
+}
+
+func (a *AppDefinition) SetEntrypoint(pool string, entrypoint []string) {
+	i := a.assignment(pool)
+	a.Assignments[i].Entrypoint = entrypoint
+}
+
+func (a *AppDefinition) GetEntrypoint(pool string) []string {
+	i := a.assignment(pool)
+	return a.Assignments[i].Entrypoint
+}
+
+func (a *AppDefinition) SetPreStart(pool string, cmd []string) {
+	i := a.assignment(pool)
+	a.Assignments[i].PreStart = cmd
+}
+
+func (a *AppDefinition) GetPreStart(pool string) []string {
+	i := a.assignment(pool)
+	return a.Assignments[i].PreStart
+}
+
+func (a *AppDefinition) SetEnvFile(pool string, path string) {
+	i := a.assignment(pool)
+	a.Assignments[i].EnvFile = path
+}
+
+func (a *AppDefinition) GetEnvFile(pool string) string {
+	i := a.assignment(pool)
+	return a.Assignments[i].EnvFile
+}
+
+func (a *AppDefinition) SetServiceMeta(pool string, meta map[string]string) {
+	i := a.assignment(pool)
+	a.Assignments[i].ServiceMeta = meta
+}
+
+func (a *AppDefinition) GetServiceMeta(pool string) map[string]string {
+	i := a.assignment(pool)
+	meta := map[string]string{}
+	for k, v := range a.Assignments[i].ServiceMeta {
+		meta[k] = v
+	}
+	return meta
+}
+
+func (a *AppDefinition) SetReadOnlyRootfs(pool string, readOnly bool) {
+	i := a.assignment(pool)
+	a.Assignments[i].ReadOnlyRootfs = readOnly
+}
+
+func (a *AppDefinition) GetReadOnlyRootfs(pool string) bool {
+	i := a.assignment(pool)
+	return a.Assignments[i].ReadOnlyRootfs
+}
+
+func (a *AppDefinition) SetTmpfs(pool string, mounts map[string]string) {
+	i := a.assignment(pool)
+	a.Assignments[i].Tmpfs = mounts
+}
+
+func (a *AppDefinition) GetTmpfs(pool string) map[string]string {
+	i := a.assignment(pool)
+	mounts := map[string]string{}
+	for k, v := range a.Assignments[i].Tmpfs {
+		mounts[k] = v
+	}
+	return mounts
+}
+
+func (a *AppDefinition) SetStopTimeout(pool string, seconds int) {
+	i := a.assignment(pool)
+	a.Assignments[i].StopTimeout = seconds
+}
+
+func (a *AppDefinition) GetStopTimeout(pool string) int {
+	i := a.assignment(pool)
+	return a.Assignments[i].StopTimeout
+}
+
 // TODO: This is to make it easier to refactor in this new config.
 //       Might want to rework this once we define what the semantics of the
 //       Assignments are.
@@ -17,7 +17,7 @@ import (
 TODO: logging!
 
 TODO: use CAS operations so that we don't have any races between
-      configuration changes
+      configuration changes (only CompareAndSwapApp does so far)
 
 The consul tree looks like:
 	galaxy/apps/env/app_name
@@ -211,6 +211,29 @@ func (c *ConsulBackend) UpdateApp(app App, env string) (bool, error) {
 	return true, nil
 }
 
+// Update the configuration for an app, only if it hasn't been modified since
+// prevID (the ModifyIndex when it was read).
+func (c *ConsulBackend) CompareAndSwapApp(app App, env string, prevID int64) (bool, error) {
+	ad := app.(*AppDefinition)
+	key := path.Join("galaxy", "apps", env, ad.Name())
+	kvp := &consul.KVPair{
+		Key:         key,
+		ModifyIndex: uint64(prevID),
+	}
+
+	var err error
+	kvp.Value, err = json.Marshal(ad)
+	if err != nil {
+		return false, err
+	}
+
+	ok, _, err := c.client.KV().CAS(kvp, nil)
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
 // Delete the configuration for an app
 // FIXME: Why does this take an App? Everything else takes a string
 func (c *ConsulBackend) DeleteApp(app App, env string) (bool, error) {
@@ -421,24 +444,37 @@ func (c *ConsulBackend) Notify(key, value string) (int, error) {
 	return 1, nil
 }
 
-func (c *ConsulBackend) Subscribe(key string) chan string {
+// Subscribe returns a channel of events fired for key. The subscription is
+// closed once stop is closed.
+func (c *ConsulBackend) Subscribe(key string, stop chan struct{}) chan string {
 	msgs := make(chan string)
-	go c.sub(key, msgs)
+	go c.sub(key, msgs, stop)
 	return msgs
 }
 
-// FIXME: This can't be shut down. Make sure that's not a problem
-func (c *ConsulBackend) sub(key string, msgs chan string) {
+// The blocking List can't be interrupted, so a stopped subscription may take
+// up to the 30s WaitTime to return.
+func (c *ConsulBackend) sub(key string, msgs chan string, stop chan struct{}) {
 	var events []*consul.UserEvent
 	var meta *consul.QueryMeta
 	var err error
 	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
 		// No way to handle failure here, just keep trying to get our first set of events.
 		// We need a successful query to get the last index to search from.
 		events, meta, err = c.client.Event().List(key, nil)
 		if err != nil {
 			log.Println("Subscribe error:", err)
-			time.Sleep(5 * time.Second)
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
 		// cache all old events
@@ -448,6 +484,12 @@ func (c *ConsulBackend) sub(key string, msgs chan string) {
 
 	lastIndex := meta.LastIndex
 	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
 		opts := &consul.QueryOptions{
 			WaitIndex: lastIndex,
 			WaitTime:  30 * time.Second,
@@ -464,7 +506,11 @@ func (c *ConsulBackend) sub(key string, msgs chan string) {
 		}
 
 		for _, event := range c.seen.Filter(events) {
-			msgs <- string(event.Payload)
+			select {
+			case msgs <- string(event.Payload):
+			case <-stop:
+				return
+			}
 		}
 
 		lastIndex = meta.LastIndex
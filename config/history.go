@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/litl/galaxy/utils"
+)
+
+var HistoryUnavailable = errors.New("app config history isn't available for this backend")
+
+// VersionInfo describes an app config as it was at config version ID.
+type VersionInfo struct {
+	ID        int64
+	Version   string
+	VersionID string
+	Env       map[string]string
+	// the deploy in effect at ID, if the version was set with Deploy
+	DeployedAt time.Time
+	DeployedBy string
+}
+
+// deployKeys are the deploy metadata in the version map. A rollback isn't a
+// deploy, so it leaves them alone.
+var deployKeys = map[string]bool{"deployedAt": true, "deployedBy": true}
+
+// historyMaps are the parts of the config restored by a rollback. Runtime
+// settings like the process count are left alone.
+func (s *AppConfig) historyMaps() []*utils.VersionedMap {
+	return []*utils.VersionedMap{
+		s.environmentVMap,
+		s.versionVMap,
+		s.portsVMap,
+	}
+}
+
+func (s *AppConfig) history() []VersionInfo {
+	// only the versions matter here, so colliding keys are fine
+	merged := utils.NewVersionedMap()
+	for _, vmap := range s.historyMaps() {
+		merged.Merge(vmap)
+	}
+
+	history := []VersionInfo{}
+	for _, id := range merged.Versions() {
+		env := map[string]string{}
+		for _, k := range s.environmentVMap.Keys() {
+			if val := s.environmentVMap.GetVersion(k, id); val != "" {
+				env[k] = val
+			}
+		}
+
+		deployedAt, _ := time.Parse(time.RFC3339Nano, s.versionVMap.GetVersion("deployedAt", id))
+		history = append(history, VersionInfo{
+			ID:         id,
+			Version:    s.versionVMap.GetVersion("version", id),
+			VersionID:  s.versionVMap.GetVersion("versionID", id),
+			Env:        env,
+			DeployedAt: deployedAt,
+			DeployedBy: s.versionVMap.GetVersion("deployedBy", id),
+		})
+	}
+	return history
+}
+
+// rollback sets every env, version and port entry back to its value at
+// version. The old values are written as new versions, so the rollback merges
+// like any other change.
+func (s *AppConfig) rollback(version int64) error {
+	found := false
+	for _, v := range s.history() {
+		if v.ID == version {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("version %d of %s not found", version, s.name)
+	}
+
+	for _, vmap := range s.historyMaps() {
+		for _, k := range vmap.Keys() {
+			if vmap == s.versionVMap && deployKeys[k] {
+				continue
+			}
+
+			old := vmap.GetVersion(k, version)
+			if old == vmap.Get(k) {
+				continue
+			}
+
+			if old == "" {
+				vmap.UnSetVersion(k, s.nextID())
+			} else {
+				vmap.SetVersion(k, old, s.nextID())
+			}
+		}
+	}
+	return nil
+}
+
+// ListVersions returns the versions of app the backend has history for,
+// oldest first. Older history is pruned as the config is updated, so only
+// the last few changes to each value can be rolled back.
+func (s *Store) ListVersions(env, app string) ([]VersionInfo, error) {
+	svcCfg, err := s.GetApp(app, env)
+	if err != nil {
+		return nil, err
+	}
+
+	appCfg, ok := svcCfg.(*AppConfig)
+	if !ok {
+		return nil, HistoryUnavailable
+	}
+	return appCfg.history(), nil
+}
+
+// Rollback restores the env, version and ports of app to what they were at
+// toVersion, as returned by ListVersions.
+func (s *Store) Rollback(env, app string, toVersion int64) error {
+	return s.ModifyApp(app, env, func(svcCfg App) error {
+		appCfg, ok := svcCfg.(*AppConfig)
+		if !ok {
+			return HistoryUnavailable
+		}
+		return appCfg.rollback(toVersion)
+	})
+}
+
+// LastDeploy returns the version of app, and when and by whom it was last
+// deployed. The time is zero and the actor empty if the app was never
+// deployed with Deploy.
+func (s *Store) LastDeploy(env, app string) (string, time.Time, string, error) {
+	svcCfg, err := s.GetApp(app, env)
+	if err != nil {
+		return "", time.Time{}, "", err
+	}
+
+	version, at, by := svcCfg.LastDeploy()
+	return version, at, by, nil
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	r, _ := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	appCfg := svcCfg.(*AppConfig)
+	svcCfg.SetVersion("app:1")
+	svcCfg.SetVersionID("img1")
+	svcCfg.EnvSet("FOO", "one")
+	appCfg.AddPort("8080", "tcp")
+	good := svcCfg.ID()
+
+	svcCfg.SetVersion("app:2")
+	svcCfg.SetVersionID("img2")
+	svcCfg.EnvSet("FOO", "two")
+	svcCfg.EnvSet("BAR", "new")
+	appCfg.ClearPorts()
+	svcCfg.SetProcesses("web", 3)
+	bad := svcCfg.ID()
+
+	versions, err := r.ListVersions("dev", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info *VersionInfo
+	for i := range versions {
+		if versions[i].ID == good {
+			info = &versions[i]
+		}
+	}
+	if info == nil {
+		t.Fatalf("ListVersions() = %v, want version %d", versions, good)
+	}
+	if info.Version != "app:1" || info.VersionID != "img1" || info.Env["FOO"] != "one" {
+		t.Errorf("ListVersions() version %d = %+v, want app:1, img1, FOO=one", good, *info)
+	}
+
+	if err := r.Rollback("dev", "app", good); err != nil {
+		t.Fatalf("Rollback(%d) = %v, want %v", good, err, nil)
+	}
+
+	svcCfg, _ = r.GetApp("app", "dev")
+	if svcCfg.Version() != "app:1" || svcCfg.VersionID() != "img1" {
+		t.Errorf("Rollback(%d) version = %s %s, want %s %s", good, svcCfg.Version(), svcCfg.VersionID(), "app:1", "img1")
+	}
+
+	if env := svcCfg.Env(); !reflect.DeepEqual(env, map[string]string{"FOO": "one"}) {
+		t.Errorf("Rollback(%d) env = %v, want %v", good, env, map[string]string{"FOO": "one"})
+	}
+
+	if ports := svcCfg.(*AppConfig).Ports(); !reflect.DeepEqual(ports, map[string]string{"8080": "tcp"}) {
+		t.Errorf("Rollback(%d) ports = %v, want %v", good, ports, map[string]string{"8080": "tcp"})
+	}
+
+	// runtime settings aren't part of the rollback
+	if svcCfg.GetProcesses("web") != 3 {
+		t.Errorf("Rollback(%d) processes = %d, want %d", good, svcCfg.GetProcesses("web"), 3)
+	}
+
+	// the rollback is a new version, not a rewrite of the old ones
+	if svcCfg.ID() <= bad {
+		t.Errorf("Rollback(%d) ID = %d, want > %d", good, svcCfg.ID(), bad)
+	}
+
+	if after, _ := r.ListVersions("dev", "app"); len(after) <= len(versions) {
+		t.Errorf("ListVersions() after rollback = %d versions, want > %d", len(after), len(versions))
+	}
+}
+
+func TestRollbackPortAddedLater(t *testing.T) {
+	r, _ := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	appCfg := svcCfg.(*AppConfig)
+	svcCfg.SetVersion("app:1")
+	appCfg.AddPort("8080", "tcp")
+	good := svcCfg.ID()
+
+	before, _ := r.ListVersions("dev", "app")
+	appCfg.AddPort("9000", "udp")
+
+	// the port is one new config version, like any other change
+	after, _ := r.ListVersions("dev", "app")
+	if len(after) != len(before)+1 || after[len(after)-1].ID != svcCfg.ID() {
+		t.Errorf("ListVersions() after AddPort = %v, want %v and version %d", after, before, svcCfg.ID())
+	}
+
+	if err := r.Rollback("dev", "app", good); err != nil {
+		t.Fatalf("Rollback(%d) = %v, want %v", good, err, nil)
+	}
+
+	if ports := appCfg.Ports(); !reflect.DeepEqual(ports, map[string]string{"8080": "tcp"}) {
+		t.Errorf("Rollback(%d) ports = %v, want %v", good, ports, map[string]string{"8080": "tcp"})
+	}
+}
+
+func TestRollbackUnknownVersion(t *testing.T) {
+	r, _ := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	svcCfg.SetVersion("app:1")
+	id := svcCfg.ID()
+
+	if err := r.Rollback("dev", "app", id+10); err == nil {
+		t.Errorf("Rollback(%d) = %v, want an error", id+10, err)
+	}
+
+	if svcCfg.ID() != id || svcCfg.Version() != "app:1" {
+		t.Errorf("Rollback(%d) changed the config to %s at %d", id+10, svcCfg.Version(), svcCfg.ID())
+	}
+}
+
+func TestListVersionsNoHistory(t *testing.T) {
+	r, b := NewTestStore()
+	assertAppCreated(t, r, "app")
+	b.GetAppFunc = func(app, env string) (App, error) {
+		return &AppDefinition{AppName: app}, nil
+	}
+
+	if _, err := r.ListVersions("dev", "app"); err != HistoryUnavailable {
+		t.Errorf("ListVersions() = %v, want %v", err, HistoryUnavailable)
+	}
+
+	if err := r.Rollback("dev", "app", 1); err != HistoryUnavailable {
+		t.Errorf("Rollback() = %v, want %v", err, HistoryUnavailable)
+	}
+}
+
+func TestLastDeploy(t *testing.T) {
+	r, _ := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	if _, at, by, err := r.LastDeploy("dev", "app"); !at.IsZero() || by != "" || err != nil {
+		t.Errorf("LastDeploy() before a deploy = %s, %q, %v, want a zero time, %q, %v", at, by, err, "", nil)
+	}
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	before := time.Now()
+	svcCfg.Deploy("app:1", "alice@deploy1")
+	first := svcCfg.ID()
+	svcCfg.EnvSet("FOO", "bar")
+	svcCfg.Deploy("app:2", "bob@deploy2")
+
+	version, at, by, err := r.LastDeploy("dev", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "app:2" || by != "bob@deploy2" || at.Before(before) {
+		t.Errorf("LastDeploy() = %s, %s, %q, want %s, after %s, %q", version, at, by, "app:2", before, "bob@deploy2")
+	}
+
+	// each version in the history has the deploy in effect then, oldest first
+	versions, _ := r.ListVersions("dev", "app")
+	deploys := []string{}
+	var last time.Time
+	for _, v := range versions {
+		if v.ID < first {
+			continue
+		}
+
+		if v.DeployedAt.Before(last) {
+			t.Errorf("ListVersions() deploy at %d = %s, want after %s", v.ID, v.DeployedAt, last)
+		}
+		last = v.DeployedAt
+		deploys = append(deploys, v.Version+" "+v.DeployedBy)
+	}
+
+	want := []string{"app:1 alice@deploy1", "app:1 alice@deploy1", "app:2 bob@deploy2"}
+	if !reflect.DeepEqual(deploys, want) {
+		t.Errorf("ListVersions() deploys = %v, want %v", deploys, want)
+	}
+
+	// a rollback restores the version, but isn't a deploy
+	if err := r.Rollback("dev", "app", first); err != nil {
+		t.Fatal(err)
+	}
+
+	if version, _, by, _ := r.LastDeploy("dev", "app"); version != "app:1" || by != "bob@deploy2" {
+		t.Errorf("LastDeploy() after rollback = %s, %q, want %s, %q", version, by, "app:1", "bob@deploy2")
+	}
+}
+
+func TestLastDeployMerge(t *testing.T) {
+	a := NewAppConfig("app", "app:1").(*AppConfig)
+	b := NewAppConfig("app", "app:1").(*AppConfig)
+
+	a.Deploy("app:2", "alice")
+	b.EnvSet("FOO", "bar")
+	b.Deploy("app:3", "bob")
+
+	a.versionVMap.Merge(b.versionVMap)
+	b.versionVMap.Merge(a.versionVMap)
+
+	for _, svcCfg := range []*AppConfig{a, b} {
+		version, at, by := svcCfg.LastDeploy()
+		if version != "app:3" || by != "bob" || at.IsZero() {
+			t.Errorf("LastDeploy() after merge = %s, %s, %q, want %s, %q", version, at, by, "app:3", "bob")
+		}
+	}
+}
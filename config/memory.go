@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"path"
 	"regexp"
 	"strings"
 
@@ -21,6 +23,7 @@ type MemoryBackend struct {
 	CreateAppFunc       func(app, env string) (bool, error)
 	GetAppFunc          func(app, env string) (App, error)
 	UpdateAppFunc       func(svcCfg App, env string) (bool, error)
+	CompareAndSwapFunc  func(svcCfg App, env string, prevID int64) (bool, error)
 	DeleteAppFunc       func(svcCfg App, env string) (bool, error)
 	ListAppFunc         func(env string) ([]AppConfig, error)
 	AssignAppFunc       func(app, env, pool string) (bool, error)
@@ -32,11 +35,15 @@ type MemoryBackend struct {
 	ListEnvsFunc        func() ([]string, error)
 	ListHostsFunc       func(env, pool string) ([]HostInfo, error)
 
+	GetServiceRegistrationFunc func(env, pool, hostIP, name, containerID string) (*ServiceRegistration, error)
+	UnregisterServiceFunc      func(env, pool, hostIP, name, containerID string) (*ServiceRegistration, error)
+
 	MembersFunc      func(key string) ([]string, error)
 	KeysFunc         func(key string) ([]string, error)
 	AddMemberFunc    func(key, value string) (int, error)
 	RemoveMemberFunc func(key, value string) (int, error)
 	NotifyFunc       func(key, value string) (int, error)
+	SubscribeFunc    func(key string, stop chan struct{}) chan string
 	SetMultiFunc     func(key string, values map[string]string) (string, error)
 }
 
@@ -99,6 +106,15 @@ func (r *MemoryBackend) UpdateApp(svcCfg App, env string) (bool, error) {
 	return false, nil
 }
 
+// The memory backend hands out the stored App itself, so there is nothing to
+// compare against by default. Use CompareAndSwapFunc to simulate conflicts.
+func (r *MemoryBackend) CompareAndSwapApp(svcCfg App, env string, prevID int64) (bool, error) {
+	if r.CompareAndSwapFunc != nil {
+		return r.CompareAndSwapFunc(svcCfg, env, prevID)
+	}
+	return true, nil
+}
+
 func (r *MemoryBackend) DeleteApp(svcCfg App, env string) (bool, error) {
 	if r.DeleteAppFunc != nil {
 		return r.DeleteAppFunc(svcCfg, env)
@@ -172,7 +188,7 @@ func (r *MemoryBackend) DeletePool(env, pool string) (bool, error) {
 
 func (r *MemoryBackend) ListPools(env string) ([]string, error) {
 	if r.ListPoolsFunc != nil {
-		return r.ListPools(env)
+		return r.ListPoolsFunc(env)
 	}
 
 	p := []string{}
@@ -292,7 +308,10 @@ func (r *MemoryBackend) Notify(key, value string) (int, error) {
 	return 0, nil
 }
 
-func (r *MemoryBackend) Subscribe(key string) chan string {
+func (r *MemoryBackend) Subscribe(key string, stop chan struct{}) chan string {
+	if r.SubscribeFunc != nil {
+		return r.SubscribeFunc(key, stop)
+	}
 	return make(chan string)
 }
 
@@ -341,15 +360,46 @@ func (r *MemoryBackend) DeleteHost(env, pool string, host HostInfo) error {
 }
 
 func (r *MemoryBackend) RegisterService(env, pool string, reg *ServiceRegistration) error {
-	panic("not implemented")
+	// stored as JSON, like the other backends
+	jsonReg, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+
+	registrationPath := path.Join(env, pool, "hosts", reg.ExternalIP, reg.Name, reg.ContainerID[0:12])
+	r.maps[registrationPath] = map[string]string{"location": string(jsonReg)}
+	return nil
 }
 
 func (r *MemoryBackend) UnregisterService(env, pool, hostIP, name, containerID string) (*ServiceRegistration, error) {
-	panic("not implemented")
+	if r.UnregisterServiceFunc != nil {
+		return r.UnregisterServiceFunc(env, pool, hostIP, name, containerID)
+	}
+
+	reg, err := r.GetServiceRegistration(env, pool, hostIP, name, containerID)
+	if err != nil || reg == nil {
+		return reg, err
+	}
+
+	delete(r.maps, path.Join(env, pool, "hosts", hostIP, name, containerID[0:12]))
+	return reg, nil
 }
 
 func (r *MemoryBackend) GetServiceRegistration(env, pool, hostIP, name, containerID string) (*ServiceRegistration, error) {
-	panic("not implemented")
+	if r.GetServiceRegistrationFunc != nil {
+		return r.GetServiceRegistrationFunc(env, pool, hostIP, name, containerID)
+	}
+
+	location := r.maps[path.Join(env, pool, "hosts", hostIP, name, containerID[0:12])]["location"]
+	if location == "" {
+		return nil, nil
+	}
+
+	reg := &ServiceRegistration{}
+	if err := json.Unmarshal([]byte(location), reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
 }
 
 func (r *MemoryBackend) ListRegistrations(env string) ([]ServiceRegistration, error) {
@@ -4,88 +4,220 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/litl/galaxy/utils"
 )
 
+// How often a Watch polls for changes when no notification arrives.
+var WatchInterval = 10 * time.Second
+
 type ConfigChange struct {
+	Name      string
 	AppConfig App
 	Restart   bool
 	Error     error
+
+	// What changed since the app was last seen by the watch. An app that
+	// appears after the watch started is compared against an empty config.
+	VersionChanged    bool
+	EnvChanged        bool
+	AssignmentChanged bool
+}
+
+// the parts of an App a watch tracks between polls
+type appState struct {
+	id        int64
+	version   string
+	versionID string
+	env       map[string]string
+	assigned  bool
+}
+
+func newAppState(app App, assigned bool) appState {
+	env := make(map[string]string)
+	for k, v := range app.Env() {
+		env[k] = v
+	}
+
+	return appState{
+		id:        app.ID(),
+		version:   app.Version(),
+		versionID: app.VersionID(),
+		env:       env,
+		assigned:  assigned,
+	}
+}
+
+func envEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+type watch struct {
+	store   *Store
+	env     string
+	pool    string
+	apps    map[string]appState
+	changes chan *ConfigChange
+	stop    chan struct{}
 }
 
-func (s *Store) CheckForChangesNow() {
-	s.pollCh <- true
+// Watch reports changes to the apps in env, on the returned channel, until
+// the returned func is called. A change is sent whenever an app's config is
+// updated, or when it is assigned to or unassigned from pool. If pool is
+// empty, assignments are not tracked. Restart notifications are passed
+// through with Restart set.
+func (s *Store) Watch(env, pool string) (<-chan *ConfigChange, func()) {
+	w := &watch{
+		store:   s,
+		env:     env,
+		pool:    pool,
+		changes: make(chan *ConfigChange, 10),
+		stop:    make(chan struct{}),
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(w.stop) })
+	}
+
+	go w.run()
+	return w.changes, cancel
 }
 
-func (s *Store) checkForChanges(env string) {
-	lastVersion := make(map[string]int64)
+func (w *watch) run() {
+	msgs := w.store.Backend.Subscribe(fmt.Sprintf("galaxy-%s", w.env), w.stop)
+
+	// get our initial state, so we only report what changes from here
 	for {
-		appCfg, err := s.ListApps(env)
-		if err != nil {
-			s.restartChan <- &ConfigChange{
-				Error: err,
-			}
-			time.Sleep(5 * time.Second)
-			continue
+		_, apps, err := w.poll()
+		if err == nil {
+			w.apps = apps
+			break
 		}
 
-		for _, config := range appCfg {
-			lastVersion[config.Name()] = config.ID()
+		if !w.send(&ConfigChange{Error: err}) {
+			return
 		}
-		break
 
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
 	}
 
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
 	for {
-		<-s.pollCh
-		appCfg, err := s.ListApps(env)
-		if err != nil {
-			s.restartChan <- &ConfigChange{
-				Error: err,
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if !w.checkForChanges() {
+				return
 			}
-			continue
-		}
-		for _, changedConfig := range appCfg {
-			changeCopy := changedConfig
-			if changedConfig.ID() != lastVersion[changedConfig.Name()] {
-				log.Printf("%s changed from %d to %d", changedConfig.Name(),
-					lastVersion[changedConfig.Name()], changedConfig.ID())
-				lastVersion[changedConfig.Name()] = changedConfig.ID()
-				s.restartChan <- &ConfigChange{
-					AppConfig: changeCopy,
+		case msg := <-msgs:
+			if msg == "config" {
+				if !w.checkForChanges() {
+					return
+				}
+			} else if strings.HasPrefix(msg, "restart") {
+				parts := strings.Split(msg, " ")
+				if !w.restartApp(parts[1]) {
+					return
 				}
+			} else {
+				log.Printf("Ignoring notification: %s\n", msg)
 			}
 		}
 	}
 }
 
-func (s *Store) checkForChangePeriodically(stop chan struct{}) {
-	// TODO: default polling interval
-	ticker := time.NewTicker(10 * time.Second)
-	for {
-		select {
-		case <-stop:
-			ticker.Stop()
-			return
-		case <-ticker.C:
-			s.CheckForChangesNow()
+// send delivers a change, returning false if the watch was cancelled first.
+func (w *watch) send(change *ConfigChange) bool {
+	select {
+	case w.changes <- change:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+func (w *watch) poll() ([]App, map[string]appState, error) {
+	appCfgs, err := w.store.ListApps(w.env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var assignments []string
+	if w.pool != "" {
+		assignments, err = w.store.ListAssignments(w.env, w.pool)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+
+	apps := make(map[string]appState)
+	for _, appCfg := range appCfgs {
+		apps[appCfg.Name()] = newAppState(appCfg, utils.StringInSlice(appCfg.Name(), assignments))
+	}
+	return appCfgs, apps, nil
 }
 
-func (s *Store) restartApp(app, env string) {
-	appCfg, err := s.GetApp(app, env)
+func (w *watch) checkForChanges() bool {
+	appCfgs, apps, err := w.poll()
 	if err != nil {
-		s.restartChan <- &ConfigChange{
-			Error: err,
+		return w.send(&ConfigChange{Error: err})
+	}
+
+	for _, appCfg := range appCfgs {
+		name := appCfg.Name()
+		current := apps[name]
+		last := w.apps[name]
+		if current.id == last.id && current.assigned == last.assigned {
+			continue
+		}
+
+		log.Printf("%s changed from %d to %d", name, last.id, current.id)
+		change := &ConfigChange{
+			Name:              name,
+			AppConfig:         appCfg,
+			VersionChanged:    current.version != last.version || current.versionID != last.versionID,
+			EnvChanged:        !envEqual(current.env, last.env),
+			AssignmentChanged: current.assigned != last.assigned,
+		}
+		if !w.send(change) {
+			return false
 		}
-		return
 	}
 
-	s.restartChan <- &ConfigChange{
+	w.apps = apps
+	return true
+}
+
+func (w *watch) restartApp(app string) bool {
+	appCfg, err := w.store.GetApp(app, w.env)
+	if err != nil {
+		return w.send(&ConfigChange{Error: err})
+	}
+
+	return w.send(&ConfigChange{
+		Name:      app,
 		Restart:   true,
 		AppConfig: appCfg,
-	}
+	})
 }
 
 func (s *Store) NotifyRestart(app, env string) error {
@@ -105,28 +237,3 @@ func (s *Store) NotifyEnvChanged(env string) error {
 	}
 	return nil
 }
-
-func (s *Store) subscribeChanges(env string) {
-
-	msgs := s.Backend.Subscribe(fmt.Sprintf("galaxy-%s", env))
-	for {
-
-		msg := <-msgs
-		if msg == "config" {
-			s.CheckForChangesNow()
-		} else if strings.HasPrefix(msg, "restart") {
-			parts := strings.Split(msg, " ")
-			app := parts[1]
-			s.restartApp(app, env)
-		} else {
-			log.Printf("Ignoring notification: %s\n", msg)
-		}
-	}
-}
-
-func (s *Store) Watch(env string, stop chan struct{}) chan *ConfigChange {
-	go s.checkForChanges(env)
-	go s.checkForChangePeriodically(stop)
-	go s.subscribeChanges(env)
-	return s.restartChan
-}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestWatch(t *testing.T) (*Store, *MemoryBackend, chan string) {
+	r, b := NewTestStore()
+	msgs := make(chan string)
+	b.SubscribeFunc = func(key string, stop chan struct{}) chan string {
+		if key != "galaxy-dev" {
+			t.Errorf("Subscribe(%q) wrong key, want %s", key, "galaxy-dev")
+		}
+		return msgs
+	}
+	return r, b, msgs
+}
+
+func nextChange(t *testing.T, changes <-chan *ConfigChange) *ConfigChange {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a config change")
+	}
+	return nil
+}
+
+func TestWatchReportsChanges(t *testing.T) {
+	r, _, msgs := newTestWatch(t)
+	assertAppCreated(t, r, "app")
+	assertPoolCreated(t, r, "web")
+
+	changes, cancel := r.Watch("dev", "web")
+	defer cancel()
+
+	// the watch only reads notifications once it has its initial state
+	msgs <- "restart app"
+	change := nextChange(t, changes)
+	if !change.Restart || change.Name != "app" {
+		t.Fatalf("Watch() = %+v, want a restart for app", change)
+	}
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	svcCfg.EnvSet("FOO", "bar")
+	msgs <- "config"
+
+	change = nextChange(t, changes)
+	if change.Name != "app" || !change.EnvChanged || change.VersionChanged || change.AssignmentChanged {
+		t.Errorf("Watch() = %+v, want only EnvChanged for app", change)
+	}
+
+	if assigned, err := r.AssignApp("app", "dev", "web"); !assigned || err != nil {
+		t.Fatalf("AssignApp(%q) = %t, %v, want %t, %v", "app", assigned, err, true, nil)
+	}
+	msgs <- "config"
+
+	change = nextChange(t, changes)
+	if change.Name != "app" || !change.AssignmentChanged || change.EnvChanged || change.VersionChanged {
+		t.Errorf("Watch() = %+v, want only AssignmentChanged for app", change)
+	}
+
+	svcCfg.SetVersion("app:2")
+	msgs <- "config"
+
+	change = nextChange(t, changes)
+	if change.Name != "app" || !change.VersionChanged || change.EnvChanged || change.AssignmentChanged {
+		t.Errorf("Watch() = %+v, want only VersionChanged for app", change)
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	interval := WatchInterval
+	WatchInterval = time.Millisecond
+	defer func() { WatchInterval = interval }()
+
+	before := runtime.NumGoroutine()
+
+	r, b := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	stopped := make(chan struct{})
+	msgs := make(chan string)
+	b.SubscribeFunc = func(key string, stop chan struct{}) chan string {
+		go func() {
+			for {
+				select {
+				case msgs <- "restart app":
+				case <-stop:
+					close(stopped)
+					return
+				}
+			}
+		}()
+		return msgs
+	}
+
+	// nobody reads the changes, so the watch ends up blocked sending
+	_, cancel := r.Watch("dev", "")
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Watch() cancel did not stop the subscription")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Watch() leaked goroutines: %d running, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"path"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -109,6 +108,86 @@ func (r *RedisBackend) UpdateApp(cfg App, env string) (bool, error) {
 	return true, nil
 }
 
+// appVMaps are the hashes an app config is stored in, by key
+func appVMaps(env string, svcCfg *AppConfig) map[string]*utils.VersionedMap {
+	return map[string]*utils.VersionedMap{
+		path.Join(env, svcCfg.name, "environment"): svcCfg.environmentVMap,
+		path.Join(env, svcCfg.name, "version"):     svcCfg.versionVMap,
+		path.Join(env, svcCfg.name, "ports"):       svcCfg.portsVMap,
+		path.Join(env, svcCfg.name, "runtime"):     svcCfg.runtimeVMap,
+	}
+}
+
+// CompareAndSwapApp saves the app config only if the stored config is still
+// at prevID. The config's hashes are WATCHed while the current ID is read,
+// and the write is a MULTI/EXEC, so it's dropped if another writer changes
+// the config in between.
+func (r *RedisBackend) CompareAndSwapApp(cfg App, env string, prevID int64) (bool, error) {
+	svcCfg := cfg.(*AppConfig)
+
+	conn := r.redisPool.Get()
+	defer conn.Close()
+
+	if err := conn.Err(); err != nil {
+		return false, err
+	}
+
+	current := NewAppConfig(svcCfg.name, "").(*AppConfig)
+	currentVMaps := appVMaps(env, current)
+
+	keys := redis.Args{}
+	for key := range currentVMaps {
+		keys = keys.Add(key)
+	}
+
+	if _, err := conn.Do("WATCH", keys...); err != nil {
+		return false, err
+	}
+
+	for key, vmap := range currentVMaps {
+		serialized, err := hgetall(conn, key)
+		if err != nil {
+			return false, err
+		}
+		vmap.UnmarshalMap(serialized)
+	}
+
+	if current.ID() != prevID {
+		_, err := conn.Do("UNWATCH")
+		return false, err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return false, err
+	}
+
+	for key, vmap := range appVMaps(env, svcCfg) {
+		if serialized := vmap.MarshalMap(); len(serialized) > 0 {
+			if err := conn.Send("HMSET", redis.Args{}.Add(key).AddFlat(serialized)...); err != nil {
+				return false, err
+			}
+		}
+
+		expired := []string{}
+		for k := range vmap.MarshalExpiredMap(5) {
+			expired = append(expired, k)
+		}
+		if len(expired) > 0 {
+			if err := conn.Send("HDEL", redis.Args{}.Add(key).AddFlat(expired)...); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+
+	// EXEC replies nil when a watched key changed, and nothing was written
+	return reply != nil, nil
+}
+
 func (r *RedisBackend) GetApp(app, env string) (App, error) {
 	svcCfg := NewAppConfig(path.Base(app), "").(*AppConfig)
 
@@ -428,9 +507,7 @@ func (r *RedisBackend) Notify(key, value string) (int, error) {
 	return redis.Int(conn.Do("PUBLISH", key, value))
 }
 
-func (r *RedisBackend) subscribeChannel(key string, msgs chan string) {
-	var wg sync.WaitGroup
-
+func (r *RedisBackend) subscribeChannel(key string, msgs chan string, stop chan struct{}) {
 	redisPool := redis.Pool{
 		MaxIdle:     1,
 		IdleTimeout: 0,
@@ -440,48 +517,71 @@ func (r *RedisBackend) subscribeChannel(key string, msgs chan string) {
 		// test every connection for now
 		TestOnBorrow: r.testOnBorrow,
 	}
+	defer redisPool.Close()
 
 	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
 		conn := redisPool.Get()
 		// no defer, doesn't return
 		if err := conn.Err(); err != nil {
 			conn.Close()
 			log.Printf("ERROR: %v\n", err)
-			time.Sleep(5 * time.Second)
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
 
-		wg.Add(1)
 		psc := redis.PubSubConn{Conn: conn}
+		done := make(chan struct{})
 		go func() {
-			defer wg.Done()
+			defer close(done)
 			for {
 				switch n := psc.Receive().(type) {
 				case redis.Message:
-					msg := string(n.Data)
-					msgs <- msg
+					select {
+					case msgs <- string(n.Data):
+					case <-stop:
+						return
+					}
 				case error:
-					psc.Close()
-					log.Printf("ERROR: %v\n", n)
+					select {
+					case <-stop:
+					default:
+						log.Printf("ERROR: %v\n", n)
+					}
 					return
 				}
 			}
 		}()
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			psc.Subscribe(key)
-			log.Printf("Monitoring for config changes on channel: %s\n", key)
-		}()
-		wg.Wait()
-		conn.Close()
+		psc.Subscribe(key)
+		log.Printf("Monitoring for config changes on channel: %s\n", key)
+
+		select {
+		case <-done:
+		case <-stop:
+			// closing the connection unblocks Receive
+			psc.Close()
+			<-done
+			return
+		}
+		psc.Close()
 	}
 }
 
-func (r *RedisBackend) Subscribe(key string) chan string {
+// Subscribe returns a channel of messages published to key. The subscription
+// is closed once stop is closed.
+func (r *RedisBackend) Subscribe(key string, stop chan struct{}) chan string {
 	msgs := make(chan string)
-	go r.subscribeChannel(key, msgs)
+	go r.subscribeChannel(key, msgs, stop)
 	return msgs
 }
 
@@ -520,6 +620,10 @@ func (r *RedisBackend) GetAll(key string) (map[string]string, error) {
 		return nil, err
 	}
 
+	return hgetall(conn, key)
+}
+
+func hgetall(conn redis.Conn, key string) (map[string]string, error) {
 	matches, err := redis.Values(conn.Do("HGETALL", key))
 	if err != nil {
 		return nil, err
@@ -532,7 +636,6 @@ func (r *RedisBackend) GetAll(key string) (map[string]string, error) {
 		serialized[key] = value
 	}
 	return serialized, nil
-
 }
 
 func (r *RedisBackend) SetMulti(key string, values map[string]string) (string, error) {
@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/garyburd/redigo/redis"
@@ -109,3 +113,191 @@ func assertInHistory(t *testing.T, history []string, cmd string) {
 		t.Fatalf("Expected %s in [%s]", cmd, strings.Join(history, ","))
 	}
 }
+
+// fakeRedis is an in-memory redis with the hash commands and WATCH/MULTI/EXEC
+// the app config uses. Each conn from its pool has its own transaction.
+type fakeRedis struct {
+	sync.Mutex
+	hashes map[string]map[string]string
+	// bumped on each write, for WATCH
+	versions map[string]int
+	// called before each HMSET or EXEC, outside the lock
+	beforeWrite func()
+}
+
+type fakeRedisConn struct {
+	r       *fakeRedis
+	watched map[string]int
+	queued  [][]interface{}
+	multi   bool
+}
+
+func newFakeRedisBackend() (*RedisBackend, *fakeRedis) {
+	r := &fakeRedis{
+		hashes:   map[string]map[string]string{},
+		versions: map[string]int{},
+	}
+	return &RedisBackend{
+		redisPool: redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return &fakeRedisConn{r: r}, nil
+			},
+		},
+	}, r
+}
+
+func (c *fakeRedisConn) Close() error                            { return nil }
+func (c *fakeRedisConn) Err() error                              { return nil }
+func (c *fakeRedisConn) Flush() error                            { return nil }
+func (c *fakeRedisConn) Receive() (reply interface{}, err error) { return nil, nil }
+
+func (c *fakeRedisConn) Send(commandName string, args ...interface{}) error {
+	if commandName == "MULTI" {
+		c.multi = true
+		return nil
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, append([]interface{}{commandName}, args...))
+		return nil
+	}
+
+	_, err := c.Do(commandName, args...)
+	return err
+}
+
+func (c *fakeRedisConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if (commandName == "HMSET" || commandName == "EXEC") && c.r.beforeWrite != nil {
+		c.r.beforeWrite()
+	}
+
+	c.r.Lock()
+	defer c.r.Unlock()
+
+	switch commandName {
+	case "WATCH":
+		c.watched = map[string]int{}
+		for _, key := range args {
+			c.watched[key.(string)] = c.r.versions[key.(string)]
+		}
+		return "OK", nil
+	case "UNWATCH":
+		c.watched = nil
+		return "OK", nil
+	case "EXEC":
+		queued, watched := c.queued, c.watched
+		c.multi, c.queued, c.watched = false, nil, nil
+
+		for key, version := range watched {
+			if c.r.versions[key] != version {
+				return nil, nil
+			}
+		}
+
+		replies := []interface{}{}
+		for _, cmd := range queued {
+			reply, err := c.r.do(cmd[0].(string), cmd[1:]...)
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, reply)
+		}
+		return replies, nil
+	}
+	return c.r.do(commandName, args...)
+}
+
+func (r *fakeRedis) do(commandName string, args ...interface{}) (interface{}, error) {
+	strs := []string{}
+	for _, arg := range args {
+		strs = append(strs, arg.(string))
+	}
+
+	switch commandName {
+	case "KEYS":
+		keys := []interface{}{}
+		for key := range r.hashes {
+			if ok, _ := path.Match(strs[0], key); ok {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return keys, nil
+	case "HGETALL":
+		values := []interface{}{}
+		for k, v := range r.hashes[strs[0]] {
+			values = append(values, []byte(k), []byte(v))
+		}
+		return values, nil
+	case "HMSET":
+		hash := r.hashes[strs[0]]
+		if hash == nil {
+			hash = map[string]string{}
+			r.hashes[strs[0]] = hash
+		}
+		for i := 1; i+1 < len(strs); i += 2 {
+			hash[strs[i]] = strs[i+1]
+		}
+		r.versions[strs[0]]++
+		return "OK", nil
+	case "HDEL":
+		deleted := 0
+		for _, field := range strs[1:] {
+			if _, ok := r.hashes[strs[0]][field]; ok {
+				delete(r.hashes[strs[0]], field)
+				deleted++
+			}
+		}
+		r.versions[strs[0]]++
+		return int64(deleted), nil
+	case "PUBLISH":
+		return int64(0), nil
+	}
+	return nil, fmt.Errorf("fake redis: %s not implemented", commandName)
+}
+
+func TestRedisModifyAppConcurrent(t *testing.T) {
+	backend, fake := newFakeRedisBackend()
+	s := &Store{Backend: backend}
+	if created, err := s.CreateApp("app", "dev"); !created || err != nil {
+		t.Fatalf("CreateApp() = %t, %v, want %t, %v", created, err, true, nil)
+	}
+
+	// hold the first write of each writer until both have read the config,
+	// so a check-then-set would lose one of the updates
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	writes := int32(0)
+	fake.beforeWrite = func() {
+		if atomic.AddInt32(&writes, 1) <= 2 {
+			arrived.Done()
+			arrived.Wait()
+		}
+	}
+
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+
+			err := s.ModifyApp("app", "dev", func(svcCfg App) error {
+				count, _ := strconv.Atoi(svcCfg.EnvGet("COUNT"))
+				svcCfg.EnvSet("COUNT", strconv.Itoa(count+1))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("ModifyApp() = %v, want %v", err, nil)
+			}
+		}()
+	}
+	done.Wait()
+
+	svcCfg, err := s.GetApp("app", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := svcCfg.EnvGet("COUNT"); count != "2" {
+		t.Errorf("COUNT after two ModifyApp() = %q, want %q", count, "2")
+	}
+}
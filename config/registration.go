@@ -3,11 +3,40 @@ package config
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
+// Service metadata is passed to the registration as container labels with
+// this prefix.
+const MetaLabelPrefix = "galaxy.meta."
+
+// MetaLabels returns the container labels for an app's service metadata.
+func MetaLabels(meta map[string]string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range meta {
+		labels[MetaLabelPrefix+k] = v
+	}
+	return labels
+}
+
+func serviceMeta(container *docker.Container) map[string]string {
+	var meta map[string]string
+	for label, v := range container.Config.Labels {
+		if !strings.HasPrefix(label, MetaLabelPrefix) {
+			continue
+		}
+
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		meta[strings.TrimPrefix(label, MetaLabelPrefix)] = v
+	}
+	return meta
+}
+
 func newServiceRegistration(container *docker.Container, hostIP, galaxyPort string) *ServiceRegistration {
 	//FIXME: We're using the first found port and assuming it's tcp.
 	//How should we handle a service that exposes multiple ports
@@ -42,6 +71,7 @@ func newServiceRegistration(container *docker.Container, hostIP, galaxyPort stri
 		StartedAt:     container.Created,
 		Image:         container.Config.Image,
 		Port:          galaxyPort,
+		Meta:          serviceMeta(container),
 	}
 
 	if externalPort != "" && internalPort != "" {
@@ -69,6 +99,7 @@ type ServiceRegistration struct {
 	VirtualHosts  []string          `json:"VIRTUAL_HOSTS"`
 	Port          string            `json:"PORT"`
 	ErrorPages    map[string]string `json:"ERROR_PAGES,omitempty"`
+	Meta          map[string]string `json:"META,omitempty"`
 	// pool is inserted only for commander dump and restore
 	Pool string
 }
@@ -14,8 +14,14 @@ import (
 
 const (
 	DefaultTTL = 60
+
+	// Number of times ModifyApp will re-read and re-apply a change before
+	// giving up on a conflicting writer.
+	UpdateRetries = 5
 )
 
+var ConfigConflict = errors.New("app config was changed concurrently")
+
 type HostInfo struct {
 	HostIP string
 	// The Pool field is currently only used for commander dump and restore
@@ -23,17 +29,13 @@ type HostInfo struct {
 }
 
 type Store struct {
-	Backend     Backend
-	TTL         uint64
-	pollCh      chan bool
-	restartChan chan *ConfigChange
+	Backend Backend
+	TTL     uint64
 }
 
 func NewStore(ttl uint64, registryURL string) *Store {
 	s := &Store{
-		TTL:         ttl,
-		pollCh:      make(chan bool),
-		restartChan: make(chan *ConfigChange, 10),
+		TTL: ttl,
 	}
 
 	u, err := url.Parse(registryURL)
@@ -255,6 +257,43 @@ func (s *Store) UpdateApp(svcCfg App, env string) (bool, error) {
 	return true, nil
 }
 
+// ModifyApp applies fn to the current config for app and saves it, as long as
+// no other writer has updated the app since it was read. On a conflict the
+// config is re-read and fn is re-applied, up to UpdateRetries times, after
+// which ConfigConflict is returned. An error returned by fn aborts the update.
+// UnknownApp is returned if the app is gone.
+func (s *Store) ModifyApp(app, env string, fn func(App) error) error {
+	for i := 0; i < UpdateRetries; i++ {
+		svcCfg, err := s.GetApp(app, env)
+		if err != nil {
+			return err
+		}
+
+		// deleted since AppExists was checked
+		if svcCfg == nil {
+			return UnknownApp
+		}
+
+		prevID := svcCfg.ID()
+		if err := fn(svcCfg); err != nil {
+			return err
+		}
+
+		swapped, err := s.Backend.CompareAndSwapApp(svcCfg, env, prevID)
+		if err != nil {
+			return err
+		}
+
+		if !swapped {
+			log.Debugf("Config for %s changed while updating, retrying", app)
+			continue
+		}
+
+		return s.NotifyEnvChanged(env)
+	}
+	return ConfigConflict
+}
+
 func (s *Store) UpdateHost(env, pool string, host HostInfo) error {
 	return s.Backend.UpdateHost(env, pool, host)
 }
@@ -2,7 +2,10 @@ package config
 
 import (
 	"errors"
+	"reflect"
 	"testing"
+
+	"github.com/fsouza/go-dockerclient"
 )
 
 func NewTestStore() (*Store, *MemoryBackend) {
@@ -379,6 +382,100 @@ func TestListEnvs(t *testing.T) {
 	}
 }
 
+func TestModifyAppRetriesOnConflict(t *testing.T) {
+	r, b := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	swaps := 0
+	b.CompareAndSwapFunc = func(svcCfg App, env string, prevID int64) (bool, error) {
+		swaps++
+		if swaps == 1 {
+			// another writer got in between our read and our write
+			other, _ := b.GetApp("app", "dev")
+			other.EnvSet("OTHER", "1")
+			return false, nil
+		}
+		return true, nil
+	}
+
+	calls := 0
+	err := r.ModifyApp("app", "dev", func(svcCfg App) error {
+		calls++
+		svcCfg.EnvSet("MINE", "1")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ModifyApp() = %v, want %v", err, nil)
+	}
+
+	if calls != 2 || swaps != 2 {
+		t.Errorf("ModifyApp() applied %d times, swapped %d times, want %d, %d", calls, swaps, 2, 2)
+	}
+
+	svcCfg, _ := r.GetApp("app", "dev")
+	if svcCfg.EnvGet("OTHER") != "1" || svcCfg.EnvGet("MINE") != "1" {
+		t.Errorf("ModifyApp() env = %v, want both OTHER and MINE set", svcCfg.Env())
+	}
+}
+
+func TestModifyAppGivesUp(t *testing.T) {
+	r, b := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	swaps := 0
+	b.CompareAndSwapFunc = func(svcCfg App, env string, prevID int64) (bool, error) {
+		swaps++
+		return false, nil
+	}
+
+	err := r.ModifyApp("app", "dev", func(svcCfg App) error {
+		svcCfg.EnvSet("MINE", "1")
+		return nil
+	})
+	if err != ConfigConflict {
+		t.Errorf("ModifyApp() = %v, want %v", err, ConfigConflict)
+	}
+
+	if swaps != UpdateRetries {
+		t.Errorf("ModifyApp() swapped %d times, want %d", swaps, UpdateRetries)
+	}
+}
+
+func TestModifyAppAbort(t *testing.T) {
+	r, b := NewTestStore()
+	assertAppCreated(t, r, "app")
+
+	b.CompareAndSwapFunc = func(svcCfg App, env string, prevID int64) (bool, error) {
+		t.Errorf("CompareAndSwapApp() called after abort")
+		return true, nil
+	}
+
+	abort := errors.New("abort")
+	err := r.ModifyApp("app", "dev", func(svcCfg App) error {
+		return abort
+	})
+	if err != abort {
+		t.Errorf("ModifyApp() = %v, want %v", err, abort)
+	}
+}
+
+func TestModifyAppDeleted(t *testing.T) {
+	r, b := NewTestStore()
+
+	// deleted between the exists check and the read
+	b.AppExistsFunc = func(app, env string) (bool, error) {
+		return true, nil
+	}
+
+	err := r.ModifyApp("app", "dev", func(svcCfg App) error {
+		t.Errorf("ModifyApp() applied fn to a deleted app")
+		return nil
+	})
+	if err != UnknownApp {
+		t.Errorf("ModifyApp() = %v, want %v", err, UnknownApp)
+	}
+}
+
 func assertAppCreated(t *testing.T, r *Store, app string) {
 	if created, err := r.CreateApp(app, "dev"); !created || err != nil {
 		t.Fatalf("CreateApp(%q) = %t, %v, want %t, %v", app,
@@ -399,3 +496,127 @@ func assertPoolCreated(t *testing.T, r *Store, pool string) {
 		t.Errorf("CreatePool(%q) = %t, %v, want %t, %v", pool, created, err, true, nil)
 	}
 }
+
+func TestServiceMetaRoundTrip(t *testing.T) {
+	r, _ := NewTestStore()
+
+	appCfg := NewAppConfig("app", "app:1")
+	meta := map[string]string{"role": "api", "weight": "10"}
+	appCfg.SetServiceMeta("web", meta)
+
+	labels := MetaLabels(appCfg.GetServiceMeta("web"))
+	labels["com.example.other"] = "ignored"
+
+	container := &docker.Container{
+		ID:   "0123456789abcdef",
+		Name: "/app_1.0",
+		Config: &docker.Config{
+			Image:  "app:1",
+			Env:    []string{"GALAXY_APP=app"},
+			Labels: labels,
+		},
+		NetworkSettings: &docker.NetworkSettings{
+			IPAddress: "172.17.0.2",
+			Ports: map[docker.Port][]docker.PortBinding{
+				"8000/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+			},
+		},
+	}
+
+	reg, err := r.RegisterService("dev", "web", "10.0.0.1", container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reg.Meta, meta) {
+		t.Errorf("RegisterService() meta = %v, want %v", reg.Meta, meta)
+	}
+
+	reg, err = r.GetServiceRegistration("dev", "web", "10.0.0.1", container)
+	if reg == nil || err != nil {
+		t.Fatalf("GetServiceRegistration() = %v, %v, want a registration", reg, err)
+	}
+
+	if !reflect.DeepEqual(reg.Meta, meta) {
+		t.Errorf("GetServiceRegistration() meta = %v, want %v", reg.Meta, meta)
+	}
+}
+
+func TestServiceMetaUnset(t *testing.T) {
+	appCfg := NewAppConfig("app", "app:1")
+	if meta := appCfg.GetServiceMeta("web"); len(meta) != 0 {
+		t.Errorf("GetServiceMeta() = %v, want none", meta)
+	}
+
+	appCfg.SetServiceMeta("web", map[string]string{"role": "api"})
+	appCfg.SetServiceMeta("web", nil)
+	if meta := appCfg.GetServiceMeta("web"); len(meta) != 0 {
+		t.Errorf("GetServiceMeta() = %v, want none", meta)
+	}
+}
+
+func TestReadOnlyRootfsAndTmpfs(t *testing.T) {
+	for _, appCfg := range []App{NewAppConfig("app", "app:1"), &AppDefinition{AppName: "app"}} {
+		if appCfg.GetReadOnlyRootfs("web") || len(appCfg.GetTmpfs("web")) != 0 {
+			t.Errorf("%T defaults = %t %v, want %t and no tmpfs", appCfg, appCfg.GetReadOnlyRootfs("web"), appCfg.GetTmpfs("web"), false)
+		}
+
+		mounts := map[string]string{"/tmp": "size=64m"}
+		appCfg.SetReadOnlyRootfs("web", true)
+		appCfg.SetTmpfs("web", mounts)
+
+		if !appCfg.GetReadOnlyRootfs("web") || !reflect.DeepEqual(appCfg.GetTmpfs("web"), mounts) {
+			t.Errorf("%T = %t %v, want %t %v", appCfg, appCfg.GetReadOnlyRootfs("web"), appCfg.GetTmpfs("web"), true, mounts)
+		}
+
+		// other pools aren't affected
+		if appCfg.GetReadOnlyRootfs("worker") || len(appCfg.GetTmpfs("worker")) != 0 {
+			t.Errorf("%T worker = %t %v, want %t and no tmpfs", appCfg, appCfg.GetReadOnlyRootfs("worker"), appCfg.GetTmpfs("worker"), false)
+		}
+	}
+}
+
+func TestStaticIP(t *testing.T) {
+	for _, appCfg := range []App{NewAppConfig("app", "app:1"), &AppDefinition{AppName: "app"}} {
+		if ip := appCfg.GetStaticIP("web"); ip != "" {
+			t.Errorf("%T GetStaticIP() = %q, want none", appCfg, ip)
+		}
+
+		appCfg.SetStaticIP("web", "10.1.0.5")
+		if ip := appCfg.GetStaticIP("web"); ip != "10.1.0.5" {
+			t.Errorf("%T GetStaticIP() = %q, want %q", appCfg, ip, "10.1.0.5")
+		}
+
+		if ip := appCfg.GetStaticIP("worker"); ip != "" {
+			t.Errorf("%T GetStaticIP(%q) = %q, want none", appCfg, "worker", ip)
+		}
+
+		appCfg.SetStaticIP("web", "")
+		if ip := appCfg.GetStaticIP("web"); ip != "" {
+			t.Errorf("%T GetStaticIP() after unset = %q, want none", appCfg, ip)
+		}
+	}
+}
+
+func TestPreStart(t *testing.T) {
+	for _, appCfg := range []App{NewAppConfig("app", "app:1"), &AppDefinition{AppName: "app"}} {
+		if hook := appCfg.GetPreStart("web"); len(hook) != 0 {
+			t.Errorf("%T GetPreStart() = %v, want none", appCfg, hook)
+		}
+
+		want := []string{"manage", "migrate", "--noinput"}
+		appCfg.SetPreStart("web", want)
+		if hook := appCfg.GetPreStart("web"); !reflect.DeepEqual(hook, want) {
+			t.Errorf("%T GetPreStart() = %v, want %v", appCfg, hook, want)
+		}
+
+		if hook := appCfg.GetPreStart("worker"); len(hook) != 0 {
+			t.Errorf("%T GetPreStart(%q) = %v, want none", appCfg, "worker", hook)
+		}
+
+		appCfg.SetPreStart("web", nil)
+		if hook := appCfg.GetPreStart("web"); len(hook) != 0 {
+			t.Errorf("%T GetPreStart() after unset = %v, want none", appCfg, hook)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"os"
 	"strings"
 	"time"
@@ -116,7 +117,7 @@ func Register(serviceRuntime *runtime.ServiceRuntime, configStore *config.Store,
 	RegisterAll(serviceRuntime, configStore, env, pool, hostIP, shuttleAddr, false)
 
 	containerEvents := make(chan runtime.ContainerEvent)
-	err := serviceRuntime.RegisterEvents(env, pool, hostIP, containerEvents)
+	err := serviceRuntime.RegisterEvents(context.Background(), env, pool, hostIP, containerEvents)
 	if err != nil {
 		log.Printf("ERROR: Unable to register docker event listener: %s", err)
 	}
@@ -42,7 +42,11 @@ func initRuntime(c *cli.Context) {
 		configStore,
 		"",
 		"127.0.0.1",
+		utils.GetEnv("GALAXY_REGISTRY_MIRROR", ""),
+		runtime.DefaultDockerTimeout,
+		runtime.DefaultPingTimeout,
 	)
+	serviceRuntime.IndexServer = utils.GetEnv("GALAXY_INDEX_SERVER", "")
 }
 
 func ensureAppParam(c *cli.Context, command string) string {
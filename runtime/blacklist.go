@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"sort"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+)
+
+// blacklist holds the IDs of containers that timed out stopping. The stop
+// operations skip them rather than hang on the same zombie every time.
+type blacklist struct {
+	sync.Mutex
+	ids map[string]bool
+}
+
+func newBlacklist() *blacklist {
+	return &blacklist{ids: make(map[string]bool)}
+}
+
+func (b *blacklist) add(id string) {
+	b.Lock()
+	defer b.Unlock()
+	b.ids[id] = true
+}
+
+func (b *blacklist) contains(id string) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.ids[id]
+}
+
+func (b *blacklist) remove(id string) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.ids, id)
+}
+
+func (b *blacklist) list() []string {
+	b.Lock()
+	defer b.Unlock()
+	ids := []string{}
+	for id := range b.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (b *blacklist) clear() {
+	b.Lock()
+	defer b.Unlock()
+	b.ids = make(map[string]bool)
+}
+
+// notBlacklisted returns containers without the blacklisted ones, so the stop
+// operations, dry run or not, select the same containers.
+func notBlacklisted(containers []*docker.Container) []*docker.Container {
+	stoppable := []*docker.Container{}
+	for _, container := range containers {
+		if blacklistedContainers.contains(container.ID) {
+			log.Printf("Container %s blacklisted. Won't try to stop.\n", container.ID)
+			continue
+		}
+		stoppable = append(stoppable, container)
+	}
+	return stoppable
+}
+
+// BlacklistedContainers returns the IDs of the containers the stop
+// operations skip because an earlier stop timed out.
+func (s *ServiceRuntime) BlacklistedContainers() []string {
+	return blacklistedContainers.list()
+}
+
+// ClearBlacklist lets the stop operations try to stop the container id again.
+func (s *ServiceRuntime) ClearBlacklist(id string) {
+	blacklistedContainers.remove(id)
+}
+
+func (s *ServiceRuntime) ClearAllBlacklisted() {
+	blacklistedContainers.clear()
+}
+
+// PruneBlacklist clears the blacklisted containers docker no longer knows
+// about, and returns their IDs.
+func (s *ServiceRuntime) PruneBlacklist() []string {
+	pruned := []string{}
+	for _, id := range blacklistedContainers.list() {
+		_, err := s.dockerClient.InspectContainer(id)
+		if _, ok := err.(*docker.NoSuchContainer); !ok {
+			continue
+		}
+
+		log.Printf("Blacklisted container %s is gone. Clearing it.\n", id)
+		blacklistedContainers.remove(id)
+		pruned = append(pruned, id)
+	}
+	return pruned
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlacklist(t *testing.T) {
+	s, client := newTestRuntime()
+	defer s.ClearAllBlacklisted()
+
+	zombie := client.addContainer("zombie_container", "web", "1", 0)
+	other := client.addContainer("other_container", "web", "1", 1)
+	blacklistedContainers.add(zombie.ID)
+	blacklistedContainers.add(other.ID)
+
+	want := []string{other.ID, zombie.ID}
+	if ids := s.BlacklistedContainers(); !reflect.DeepEqual(ids, want) {
+		t.Errorf("BlacklistedContainers() = %v, want %v", ids, want)
+	}
+
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		t.Errorf("StopContainer(%s) called for a blacklisted container", id)
+		return nil
+	}
+	s.stopContainer(zombie)
+
+	s.ClearBlacklist(zombie.ID)
+	if ids := s.BlacklistedContainers(); !reflect.DeepEqual(ids, []string{other.ID}) {
+		t.Errorf("BlacklistedContainers() = %v, want %v", ids, []string{other.ID})
+	}
+
+	s.ClearAllBlacklisted()
+	if ids := s.BlacklistedContainers(); len(ids) != 0 {
+		t.Errorf("BlacklistedContainers() = %v, want none", ids)
+	}
+}
+
+func TestPruneBlacklist(t *testing.T) {
+	s, client := newTestRuntime()
+	defer s.ClearAllBlacklisted()
+
+	zombie := client.addContainer("zombie_container", "web", "1", 0)
+	blacklistedContainers.add(zombie.ID)
+	blacklistedContainers.add("removed_container")
+
+	if pruned := s.PruneBlacklist(); !reflect.DeepEqual(pruned, []string{"removed_container"}) {
+		t.Errorf("PruneBlacklist() = %v, want %v", pruned, []string{"removed_container"})
+	}
+
+	if ids := s.BlacklistedContainers(); !reflect.DeepEqual(ids, []string{zombie.ID}) {
+		t.Errorf("BlacklistedContainers() = %v, want %v", ids, []string{zombie.ID})
+	}
+}
@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerConfig is the part of the docker CLI's config.json that names
+// credential helpers. credHelpers maps a registry host to a helper, and
+// credsStore is the helper for every other registry.
+type dockerConfig struct {
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath returns the docker CLI's config.json, in DOCKER_CONFIG or
+// ~/.docker.
+func dockerConfigPath(getenv func(string) string) string {
+	dir := getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(getenv("HOME"), ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// credHelper returns the credential helper configured for registry, or "" if
+// the static auths should be used. A missing or unreadable config means no
+// helper.
+func credHelper(configPath, registry string) string {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return ""
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return ""
+	}
+
+	for reg, helper := range cfg.CredHelpers {
+		// the keys may be urls, like the static auths
+		if u, e := url.Parse(reg); e == nil && u.Host != "" {
+			reg = u.Host
+		}
+		if reg == registry {
+			return helper
+		}
+	}
+	return cfg.CredsStore
+}
+
+// helperAuth gets the credentials for serverURL from docker-credential-helper,
+// using the docker credential helper protocol: the server URL on stdin of
+// "get", and a JSON Username and Secret on stdout. found is false if the
+// helper has no credentials for the server.
+func helperAuth(helper, serverURL string) (auth docker.AuthConfiguration, found bool, err error) {
+	name := "docker-credential-" + helper
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return auth, false, &CredentialHelperError{Helper: name, Registry: serverURL, Cause: err}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// helpers exit non-zero with this message when they have nothing
+		// stored for the server
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return auth, false, nil
+		}
+		return auth, false, &CredentialHelperError{Helper: name, Registry: serverURL, Cause: err}
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return auth, false, &CredentialHelperError{Helper: name, Registry: serverURL, Cause: err}
+	}
+
+	return docker.AuthConfiguration{
+		Username:      creds.Username,
+		Password:      creds.Secret,
+		ServerAddress: serverURL,
+	}, true, nil
+}
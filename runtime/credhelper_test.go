@@ -0,0 +1,141 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fakeHelper answers for ecr.example.com and the index, and has nothing
+// stored for anything else.
+const fakeHelper = `#!/bin/sh
+[ "$1" = get ] || exit 2
+read server
+case "$server" in
+ecr.example.com|https://index.docker.io/v1/)
+	echo "{\"ServerURL\":\"$server\",\"Username\":\"AWS\",\"Secret\":\"secret for $server\"}"
+	;;
+*)
+	echo "credentials not found in native keychain"
+	exit 1
+	;;
+esac
+`
+
+// credHelperEnv writes a docker config.json and a docker-credential-fake
+// helper to a temp dir, and points DOCKER_CONFIG and PATH at it. The
+// returned func restores them.
+func credHelperEnv(t *testing.T, config string) func() {
+	dir, err := ioutil.TempDir("", "credhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(fakeHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerConfig, path := os.Getenv("DOCKER_CONFIG"), os.Getenv("PATH")
+	os.Setenv("DOCKER_CONFIG", dir)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("DOCKER_CONFIG", dockerConfig)
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func staticAuths() (*docker.AuthConfigurations, error) {
+	return &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{
+		"registry.example.com":        {Username: "static"},
+		"https://index.docker.io/v1/": {Username: "index"},
+	}}, nil
+}
+
+func TestFindAuthCredHelper(t *testing.T) {
+	defer credHelperEnv(t, `{"credHelpers": {"https://ecr.example.com": "fake"}}`)()
+	defer func(f func() (*docker.AuthConfigurations, error)) { dockerAuths = f }(dockerAuths)
+	dockerAuths = staticAuths
+
+	s, client := newTestRuntime()
+
+	var auth docker.AuthConfiguration
+	client.PullImageFunc = func(opts docker.PullImageOptions, a docker.AuthConfiguration) error {
+		auth = a
+		return nil
+	}
+	s.PullImage("ecr.example.com/web:1", "")
+
+	want := docker.AuthConfiguration{Username: "AWS", Password: "secret for ecr.example.com", ServerAddress: "ecr.example.com"}
+	if auth != want {
+		t.Errorf("PullImage() auth = %+v, want %+v", auth, want)
+	}
+
+	// registries without a helper use the static config
+	if auth, err := s.findAuth("registry.example.com"); auth.Username != "static" || err != nil {
+		t.Errorf("findAuth() = %q, %v, want %q, %v", auth.Username, err, "static", nil)
+	}
+}
+
+func TestFindAuthCredsStore(t *testing.T) {
+	defer credHelperEnv(t, `{"credsStore": "fake"}`)()
+	defer func(f func() (*docker.AuthConfigurations, error)) { dockerAuths = f }(dockerAuths)
+	dockerAuths = staticAuths
+
+	s, _ := newTestRuntime()
+
+	// index images are looked up by the index server
+	auth, err := s.findAuth("litl")
+	if err != nil {
+		t.Fatalf("findAuth() = %v, want %v", err, nil)
+	}
+
+	if auth.Username != "AWS" || auth.Password != "secret for https://index.docker.io/v1/" {
+		t.Errorf("findAuth() = %+v, want the helper's credentials for the index", auth)
+	}
+
+	// the helper has nothing for this one, so the static config is used
+	if auth, err := s.findAuth("registry.example.com"); auth.Username != "static" || err != nil {
+		t.Errorf("findAuth() = %q, %v, want %q, %v", auth.Username, err, "static", nil)
+	}
+}
+
+func TestFindAuthCredHelperMissing(t *testing.T) {
+	defer credHelperEnv(t, `{"credHelpers": {"ecr.example.com": "missing"}}`)()
+	defer func(f func() (*docker.AuthConfigurations, error)) { dockerAuths = f }(dockerAuths)
+	dockerAuths = staticAuths
+
+	s, client := newTestRuntime()
+	client.PullImageFunc = func(opts docker.PullImageOptions, a docker.AuthConfiguration) error {
+		t.Errorf("PullImage() pulled without the helper's credentials")
+		return nil
+	}
+
+	_, err := s.PullImage("ecr.example.com/web:1", "")
+	helperErr, ok := err.(*CredentialHelperError)
+	if !ok {
+		t.Fatalf("PullImage() = %v, want a *CredentialHelperError", err)
+	}
+
+	if helperErr.Helper != "docker-credential-missing" || helperErr.Registry != "ecr.example.com" {
+		t.Errorf("PullImage() = %s %s, want %s %s", helperErr.Helper, helperErr.Registry, "docker-credential-missing", "ecr.example.com")
+	}
+}
+
+func TestFindAuthNoDockerConfig(t *testing.T) {
+	defer credHelperEnv(t, `not json`)()
+	defer func(f func() (*docker.AuthConfigurations, error)) { dockerAuths = f }(dockerAuths)
+	dockerAuths = staticAuths
+
+	s, _ := newTestRuntime()
+	if auth, err := s.findAuth("registry.example.com"); auth.Username != "static" || err != nil {
+		t.Errorf("findAuth() = %q, %v, want %q, %v", auth.Username, err, "static", nil)
+	}
+}
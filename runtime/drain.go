@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"time"
+
+	"github.com/litl/galaxy/log"
+)
+
+// drainWait waits out the drain timeout. Tests replace it.
+var drainWait = time.Sleep
+
+// Drain takes this host's containers out of service discovery, waits
+// drainTimeout for in-flight requests to finish, then stops them all. A
+// failed deregistration is logged and the containers are stopped anyway, so
+// a shutdown never hangs on the config store.
+func (s *ServiceRuntime) Drain(env, pool, hostIP string, drainTimeout time.Duration) error {
+	removed, err := s.UnRegisterAll(env, pool, hostIP)
+	if err != nil {
+		log.Errorf("ERROR: Unable to unregister all containers, stopping anyway: %s", err)
+	}
+
+	// nothing was in service, so there's nothing to drain
+	if (len(removed) > 0 || err != nil) && drainTimeout > 0 {
+		log.Printf("Draining %d containers for %s", len(removed), drainTimeout)
+		drainWait(drainTimeout)
+	}
+
+	_, err = s.StopAll(env)
+	return err
+}
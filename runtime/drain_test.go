@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+)
+
+// drainRuntime returns a runtime that records each unregister, drain wait and
+// stop in calls.
+func drainRuntime(calls *[]string) (*ServiceRuntime, *config.MemoryBackend) {
+	s, client := newTestRuntime()
+	client.addContainer("web_container_0", "web", "1", 0)
+	client.addContainer("api_container_0", "api", "1", 0)
+
+	backend := config.NewMemoryBackend()
+	backend.UnregisterServiceFunc = func(env, pool, hostIP, name, containerID string) (*config.ServiceRegistration, error) {
+		*calls = append(*calls, "unregister "+containerID)
+		return &config.ServiceRegistration{Name: name, ContainerID: containerID}, nil
+	}
+	s.configStore = &config.Store{Backend: backend}
+
+	drainWait = func(d time.Duration) {
+		*calls = append(*calls, "wait "+d.String())
+	}
+
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		*calls = append(*calls, "stop "+id)
+		return nil
+	}
+	return s, backend
+}
+
+func TestDrain(t *testing.T) {
+	defer func(f func(time.Duration)) { drainWait = f }(drainWait)
+
+	calls := []string{}
+	s, _ := drainRuntime(&calls)
+
+	if err := s.Drain("dev", "web", "127.0.0.1", 30*time.Second); err != nil {
+		t.Fatalf("Drain() = %v, want %v", err, nil)
+	}
+
+	want := []string{
+		"unregister web_container_0",
+		"unregister api_container_0",
+		"wait 30s",
+		"stop web_container_0",
+		"stop api_container_0",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Drain() = %v, want %v", calls, want)
+	}
+}
+
+func TestDrainUnregisterError(t *testing.T) {
+	defer func(f func(time.Duration)) { drainWait = f }(drainWait)
+
+	calls := []string{}
+	s, backend := drainRuntime(&calls)
+	backend.UnregisterServiceFunc = func(env, pool, hostIP, name, containerID string) (*config.ServiceRegistration, error) {
+		calls = append(calls, "unregister "+containerID)
+		return nil, errors.New("connection refused")
+	}
+
+	if err := s.Drain("dev", "web", "127.0.0.1", time.Second); err != nil {
+		t.Fatalf("Drain() = %v, want %v", err, nil)
+	}
+
+	// still waits and stops everything
+	stops := 0
+	for _, call := range calls {
+		if strings.HasPrefix(call, "stop ") {
+			stops++
+		}
+	}
+	if calls[len(calls)-3] != "wait 1s" || stops != 2 {
+		t.Errorf("Drain() = %v, want a wait and %d stops after unregister", calls, 2)
+	}
+}
+
+func TestDrainStopError(t *testing.T) {
+	defer func(f func(time.Duration)) { drainWait = f }(drainWait)
+
+	calls := []string{}
+	s, _ := drainRuntime(&calls)
+
+	listErr := errors.New("docker is gone")
+	s.dockerClient.(*fakeDocker).ListContainersFunc = func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+		return nil, listErr
+	}
+
+	if err := s.Drain("dev", "web", "127.0.0.1", time.Second); err != listErr {
+		t.Errorf("Drain() = %v, want %v", err, listErr)
+	}
+}
+
+func TestDrainNoContainers(t *testing.T) {
+	defer func(f func(time.Duration)) { drainWait = f }(drainWait)
+
+	calls := []string{}
+	s, _ := drainRuntime(&calls)
+	s.dockerClient.(*fakeDocker).containers = nil
+
+	if err := s.Drain("dev", "web", "127.0.0.1", time.Minute); err != nil {
+		t.Fatalf("Drain() = %v, want %v", err, nil)
+	}
+
+	if len(calls) != 0 {
+		t.Errorf("Drain() with no containers = %v, want no calls", calls)
+	}
+}
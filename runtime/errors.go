@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ErrImageNotFound is returned by PullImage when the registry doesn't have
+// the image and there's no local copy to fall back on.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrContainerStopTimeout is returned when docker didn't stop a container in
+// time. The container is blacklisted, so it isn't tried again.
+var ErrContainerStopTimeout = errors.New("timed out stopping container")
+
+// ErrPingTimeout is returned by Ping when docker doesn't answer within the
+// ping timeout.
+var ErrPingTimeout = errors.New("timed out pinging docker")
+
+// PullError is returned by PullImage when every attempt to pull failed
+type PullError struct {
+	Version  string
+	Attempts int
+	// the error from the last attempt
+	Cause error
+}
+
+func (e *PullError) Error() string {
+	return fmt.Sprintf("unable to pull %s after %d attempts: %s", e.Version, e.Attempts, e.Cause)
+}
+
+func (e *PullError) Unwrap() error {
+	return e.Cause
+}
+
+// ImageMismatchError is returned by PullImage when the image it ended up
+// with isn't the one asked for, like when the tag still points to an old
+// build.
+type ImageMismatchError struct {
+	Version string
+	ID      string
+	Want    string
+}
+
+func (e *ImageMismatchError) Error() string {
+	return fmt.Sprintf("image %s is %s, want %s", e.Version, e.ID, e.Want)
+}
+
+// InstanceTimeoutError is returned by WaitForInstances when app didn't reach
+// the wanted instance count in time.
+type InstanceTimeoutError struct {
+	App       string
+	VersionID string
+	Want      int
+	// the count when the wait gave up
+	Count int
+	// context.DeadlineExceeded, or context.Canceled if the caller gave up
+	Cause error
+}
+
+func (e *InstanceTimeoutError) Error() string {
+	return fmt.Sprintf("%s has %d of %d instances running: %s", e.App, e.Count, e.Want, e.Cause)
+}
+
+func (e *InstanceTimeoutError) Unwrap() error {
+	return e.Cause
+}
+
+// PreStartError is returned by Start when the app's pre-start hook exited
+// non-zero. The service container isn't created or started.
+type PreStartError struct {
+	App      string
+	Version  string
+	ExitCode int
+	// what the hook wrote to stderr
+	Stderr string
+}
+
+func (e *PreStartError) Error() string {
+	return fmt.Sprintf("pre-start hook for %s version %s exited with %d", e.App, e.Version, e.ExitCode)
+}
+
+// LogDriverError is returned by StreamLogs when the container's log driver
+// doesn't let docker read its logs back, like syslog.
+type LogDriverError struct {
+	App      string
+	Instance int
+	Driver   string
+}
+
+func (e *LogDriverError) Error() string {
+	return fmt.Sprintf("logs for %s instance %d can't be read from the %s log driver", e.App, e.Instance, e.Driver)
+}
+
+// CredentialHelperError is returned when the docker credential helper
+// configured for a registry is missing or fails.
+type CredentialHelperError struct {
+	Helper   string
+	Registry string
+	Cause    error
+}
+
+func (e *CredentialHelperError) Error() string {
+	if _, ok := e.Cause.(*exec.Error); ok {
+		return fmt.Sprintf("credential helper %s for %s isn't installed: %s", e.Helper, e.Registry, e.Cause)
+	}
+	return fmt.Sprintf("credential helper %s for %s failed: %s", e.Helper, e.Registry, e.Cause)
+}
+
+func (e *CredentialHelperError) Unwrap() error {
+	return e.Cause
+}
+
+// isNotFound is true if docker or the registry answered with a 404
+func isNotFound(err error) bool {
+	if e, ok := err.(*docker.Error); ok {
+		return e.Status == 404
+	}
+	return err == docker.ErrNoSuchImage
+}
@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestPullImageNotFound(t *testing.T) {
+	s, client := newTestRuntime()
+
+	pulls := 0
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		pulls++
+		return &docker.Error{Status: 404, Message: "image not found"}
+	}
+
+	if image, err := s.PullImage("web:1", ""); err != ErrImageNotFound || image != nil {
+		t.Errorf("PullImage() = %v, %v, want %v, %v", image, err, nil, ErrImageNotFound)
+	}
+
+	// a 404 is never retried
+	if pulls != 1 {
+		t.Errorf("PullImage() pulled %d times, want %d", pulls, 1)
+	}
+}
+
+func TestPullImagePullError(t *testing.T) {
+	s, client := newTestRuntime()
+
+	cause := errors.New("connection reset")
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		return cause
+	}
+
+	_, err := s.PullImage("web:1", "")
+	pullErr, ok := err.(*PullError)
+	if !ok {
+		t.Fatalf("PullImage() = %v, want a *PullError", err)
+	}
+
+	if pullErr.Version != "web:1" || pullErr.Attempts != 4 || pullErr.Cause != cause || pullErr.Unwrap() != cause {
+		t.Errorf("PullImage() = %+v, want web:1 after 4 attempts from %v", *pullErr, cause)
+	}
+}
+
+func TestPullImageMismatchError(t *testing.T) {
+	s, client, _ := staleTagRuntime()
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		return nil
+	}
+
+	_, err := s.PullImage("web:1", "img2")
+	mismatch, ok := err.(*ImageMismatchError)
+	if !ok {
+		t.Fatalf("PullImage() = %v, want an *ImageMismatchError", err)
+	}
+
+	if want := (ImageMismatchError{Version: "web:1", ID: "img1", Want: "img2"}); *mismatch != want {
+		t.Errorf("PullImage() = %+v, want %+v", *mismatch, want)
+	}
+}
+
+func TestStopContainerTimeout(t *testing.T) {
+	buffer := stopWatchdogBuffer
+	stopWatchdogBuffer = 10 * time.Millisecond
+	defer func() { stopWatchdogBuffer = buffer }()
+
+	s, client := newTestRuntime()
+	zombie := client.addContainer("zombie_container", "web", "1", 0)
+	zombie.Config.Env = append(zombie.Config.Env, "GALAXY_STOP_TIMEOUT=1")
+	defer blacklistedContainers.remove(zombie.ID)
+
+	hung := make(chan struct{})
+	defer close(hung)
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		<-hung
+		return nil
+	}
+
+	if err := s.stopContainer(zombie); err != ErrContainerStopTimeout {
+		t.Errorf("stopContainer() = %v, want %v", err, ErrContainerStopTimeout)
+	}
+
+	// blacklisted, so it isn't waited on again
+	if err := s.stopContainer(zombie); err != nil {
+		t.Errorf("stopContainer() again = %v, want %v", err, nil)
+	}
+}
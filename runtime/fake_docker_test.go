@@ -0,0 +1,300 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fakeDocker is a dockerAPI for tests. Containers are kept in memory, and
+// any method can be overridden with its Func field.
+type fakeDocker struct {
+	containers []*docker.Container
+	images     []*docker.Image
+	networks   []*docker.Network
+	created    int
+
+	AddEventListenerFunc    func(listener chan<- *docker.APIEvents) error
+	AttachToContainerFunc   func(opts docker.AttachToContainerOptions) error
+	ConnectNetworkFunc      func(id string, opts docker.NetworkConnectionOptions) error
+	CreateContainerFunc     func(opts docker.CreateContainerOptions) (*docker.Container, error)
+	InspectContainerFunc    func(id string) (*docker.Container, error)
+	InspectImageFunc        func(name string) (*docker.Image, error)
+	KillContainerFunc       func(opts docker.KillContainerOptions) error
+	ListContainersFunc      func(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	ListImagesFunc          func(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	LogsFunc                func(opts docker.LogsOptions) error
+	NetworkInfoFunc         func(id string) (*docker.Network, error)
+	PingFunc                func(ctx context.Context) error
+	PullImageFunc           func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	RemoveContainerFunc     func(opts docker.RemoveContainerOptions) error
+	RemoveImageFunc         func(name string) error
+	RemoveEventListenerFunc func(listener chan *docker.APIEvents) error
+	StartContainerFunc      func(id string, hostConfig *docker.HostConfig) error
+	StatsFunc               func(opts docker.StatsOptions) error
+	StopContainerFunc       func(id string, timeout uint) error
+	TagImageFunc            func(name string, opts docker.TagImageOptions) error
+	WaitContainerFunc       func(id string) (int, error)
+}
+
+func init() {
+	// don't wait on the EC2 metadata service in tests
+	publicHostname = func() (string, error) {
+		return "127.0.0.1", nil
+	}
+}
+
+func newTestRuntime() (*ServiceRuntime, *fakeDocker) {
+	client := &fakeDocker{}
+	return &ServiceRuntime{dockerClient: client}, client
+}
+
+// addContainer adds a running container for an app instance
+func (f *fakeDocker) addContainer(id, app, version string, instance int) *docker.Container {
+	container := &docker.Container{
+		ID:   id,
+		Name: "/" + app + "." + strconv.Itoa(instance),
+		Config: &docker.Config{
+			Image: version,
+			Env: []string{
+				"GALAXY_APP=" + app,
+				"GALAXY_VERSION=" + version,
+				"GALAXY_INSTANCE=" + strconv.Itoa(instance),
+			},
+		},
+		State: docker.State{
+			Running: true,
+		},
+		HostConfig: &docker.HostConfig{},
+	}
+	f.containers = append(f.containers, container)
+	return container
+}
+
+func (f *fakeDocker) AddEventListener(listener chan<- *docker.APIEvents) error {
+	if f.AddEventListenerFunc != nil {
+		return f.AddEventListenerFunc(listener)
+	}
+	return nil
+}
+
+func (f *fakeDocker) AttachToContainer(opts docker.AttachToContainerOptions) error {
+	if f.AttachToContainerFunc != nil {
+		return f.AttachToContainerFunc(opts)
+	}
+	return nil
+}
+
+func (f *fakeDocker) ConnectNetwork(id string, opts docker.NetworkConnectionOptions) error {
+	if f.ConnectNetworkFunc != nil {
+		return f.ConnectNetworkFunc(id, opts)
+	}
+
+	if _, err := f.NetworkInfo(id); err != nil {
+		return err
+	}
+	_, err := f.InspectContainer(opts.Container)
+	return err
+}
+
+func (f *fakeDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if f.CreateContainerFunc != nil {
+		return f.CreateContainerFunc(opts)
+	}
+
+	f.created++
+	container := &docker.Container{
+		ID:         fmt.Sprintf("%064d", f.created),
+		Name:       "/" + opts.Name,
+		Config:     opts.Config,
+		HostConfig: opts.HostConfig,
+		Image:      opts.Config.Image,
+	}
+	f.containers = append(f.containers, container)
+	return container, nil
+}
+
+func (f *fakeDocker) InspectContainer(id string) (*docker.Container, error) {
+	if f.InspectContainerFunc != nil {
+		return f.InspectContainerFunc(id)
+	}
+
+	for _, c := range f.containers {
+		if c.ID == id || c.Name == "/"+id {
+			return c, nil
+		}
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+func (f *fakeDocker) InspectImage(name string) (*docker.Image, error) {
+	if f.InspectImageFunc != nil {
+		return f.InspectImageFunc(name)
+	}
+
+	for _, i := range f.images {
+		if i.ID == name {
+			return i, nil
+		}
+	}
+	return nil, docker.ErrNoSuchImage
+}
+
+func (f *fakeDocker) KillContainer(opts docker.KillContainerOptions) error {
+	if f.KillContainerFunc != nil {
+		return f.KillContainerFunc(opts)
+	}
+
+	_, err := f.InspectContainer(opts.ID)
+	return err
+}
+
+func (f *fakeDocker) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	if f.ListContainersFunc != nil {
+		return f.ListContainersFunc(opts)
+	}
+
+	containers := []docker.APIContainers{}
+	for _, c := range f.containers {
+		if !opts.All && !c.State.Running {
+			continue
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:    c.ID,
+			Image: c.Config.Image,
+			Names: []string{c.Name},
+		})
+	}
+	return containers, nil
+}
+
+func (f *fakeDocker) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	if f.ListImagesFunc != nil {
+		return f.ListImagesFunc(opts)
+	}
+
+	images := []docker.APIImages{}
+	for _, i := range f.images {
+		images = append(images, docker.APIImages{ID: i.ID})
+	}
+	return images, nil
+}
+
+func (f *fakeDocker) Logs(opts docker.LogsOptions) error {
+	if f.LogsFunc != nil {
+		return f.LogsFunc(opts)
+	}
+	return nil
+}
+
+func (f *fakeDocker) NetworkInfo(id string) (*docker.Network, error) {
+	if f.NetworkInfoFunc != nil {
+		return f.NetworkInfoFunc(id)
+	}
+
+	for _, n := range f.networks {
+		if n.ID == id || n.Name == id {
+			return n, nil
+		}
+	}
+	return nil, &docker.NoSuchNetwork{ID: id}
+}
+
+func (f *fakeDocker) PingWithContext(ctx context.Context) error {
+	if f.PingFunc != nil {
+		return f.PingFunc(ctx)
+	}
+	return nil
+}
+
+func (f *fakeDocker) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	if f.PullImageFunc != nil {
+		return f.PullImageFunc(opts, auth)
+	}
+	return nil
+}
+
+func (f *fakeDocker) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	if f.RemoveContainerFunc != nil {
+		return f.RemoveContainerFunc(opts)
+	}
+
+	for i, c := range f.containers {
+		if c.ID == opts.ID {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			return nil
+		}
+	}
+	return &docker.NoSuchContainer{ID: opts.ID}
+}
+
+func (f *fakeDocker) RemoveImage(name string) error {
+	if f.RemoveImageFunc != nil {
+		return f.RemoveImageFunc(name)
+	}
+
+	for i, img := range f.images {
+		if img.ID == name {
+			f.images = append(f.images[:i], f.images[i+1:]...)
+			return nil
+		}
+	}
+	return docker.ErrNoSuchImage
+}
+
+func (f *fakeDocker) RemoveEventListener(listener chan *docker.APIEvents) error {
+	if f.RemoveEventListenerFunc != nil {
+		return f.RemoveEventListenerFunc(listener)
+	}
+	return nil
+}
+
+func (f *fakeDocker) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	if f.StartContainerFunc != nil {
+		return f.StartContainerFunc(id, hostConfig)
+	}
+
+	c, err := f.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	c.State.Running = true
+	return nil
+}
+
+func (f *fakeDocker) Stats(opts docker.StatsOptions) error {
+	if f.StatsFunc != nil {
+		return f.StatsFunc(opts)
+	}
+	close(opts.Stats)
+	return nil
+}
+
+func (f *fakeDocker) StopContainer(id string, timeout uint) error {
+	if f.StopContainerFunc != nil {
+		return f.StopContainerFunc(id, timeout)
+	}
+
+	c, err := f.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	c.State.Running = false
+	return nil
+}
+
+func (f *fakeDocker) TagImage(name string, opts docker.TagImageOptions) error {
+	if f.TagImageFunc != nil {
+		return f.TagImageFunc(name, opts)
+	}
+	return nil
+}
+
+func (f *fakeDocker) WaitContainer(id string) (int, error) {
+	if f.WaitContainerFunc != nil {
+		return f.WaitContainerFunc(id)
+	}
+	return 0, nil
+}
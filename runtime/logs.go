@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type LogOptions struct {
+	// Keep streaming new output until the context is canceled
+	Follow bool
+	// Only return this many lines from the end of the logs. All lines if 0.
+	Tail int
+	// Only return output since this time, if set
+	Since time.Time
+	// Receives the container's stderr. If nil, stderr is written to the same
+	// writer as stdout.
+	Stderr io.Writer
+}
+
+// readableLogDrivers are the log drivers docker can serve logs from
+var readableLogDrivers = map[string]bool{
+	"json-file": true,
+	"journald":  true,
+	"local":     true,
+}
+
+// logDriver returns the container's log driver, or "" if it uses the
+// daemon's default.
+func logDriver(container *docker.Container) string {
+	if container.HostConfig == nil {
+		return ""
+	}
+	return container.HostConfig.LogConfig.Type
+}
+
+// StreamLogs writes the logs of a running instance of app to w. The
+// container's stdout and stderr are demultiplexed, with stderr going to
+// opts.Stderr if it's set. An error writing the logs ends the stream and is
+// returned. Canceling ctx closes the connection to docker and ends the stream
+// without an error, even if the container is quiet. The logs are only
+// available if the container uses a log driver docker can read back, like
+// json-file. Otherwise, including for the syslog driver Start uses, a
+// *LogDriverError is returned.
+func (s *ServiceRuntime) StreamLogs(ctx context.Context, app string, instance int, opts LogOptions, w io.Writer) error {
+	container, err := s.findInstance(app, instance)
+	if err != nil {
+		return err
+	}
+
+	if driver := logDriver(container); driver != "" && !readableLogDrivers[driver] {
+		return &LogDriverError{App: app, Instance: instance, Driver: driver}
+	}
+
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = w
+	}
+
+	logOpts := docker.LogsOptions{
+		Container:    container.ID,
+		Context:      ctx,
+		OutputStream: w,
+		ErrorStream:  stderr,
+		Follow:       opts.Follow,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         "all",
+		// a tty container's output isn't multiplexed
+		RawTerminal: container.Config.Tty,
+	}
+
+	if opts.Tail > 0 {
+		logOpts.Tail = strconv.Itoa(opts.Tail)
+	}
+
+	if !opts.Since.IsZero() {
+		logOpts.Since = opts.Since.Unix()
+	}
+
+	err = s.dockerClient.Logs(logOpts)
+	if err != nil && err == ctx.Err() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestStreamLogs(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+	client.addContainer("bbb", "web", "web:1", 1)
+
+	since := time.Unix(1400000000, 0)
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		if opts.Container != "bbb" {
+			t.Errorf("Logs() container = %s, want %s", opts.Container, "bbb")
+		}
+
+		if !opts.Follow || opts.Tail != "10" || opts.Since != since.Unix() {
+			t.Errorf("Logs() = follow %t tail %s since %d, want %t %s %d",
+				opts.Follow, opts.Tail, opts.Since, true, "10", since.Unix())
+		}
+
+		opts.OutputStream.Write([]byte("out 1\n"))
+		opts.ErrorStream.Write([]byte("err 1\n"))
+		opts.OutputStream.Write([]byte("out 2\n"))
+		return nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := s.StreamLogs(context.Background(), "web", 1, LogOptions{
+		Follow: true,
+		Tail:   10,
+		Since:  since,
+		Stderr: &stderr,
+	}, &stdout)
+	if err != nil {
+		t.Fatalf("StreamLogs() = %v, want %v", err, nil)
+	}
+
+	if stdout.String() != "out 1\nout 2\n" {
+		t.Errorf("StreamLogs() stdout = %q, want %q", stdout.String(), "out 1\nout 2\n")
+	}
+
+	if stderr.String() != "err 1\n" {
+		t.Errorf("StreamLogs() stderr = %q, want %q", stderr.String(), "err 1\n")
+	}
+}
+
+func TestStreamLogsCombined(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		if opts.Tail != "all" {
+			t.Errorf("Logs() tail = %s, want %s", opts.Tail, "all")
+		}
+		opts.OutputStream.Write([]byte("out\n"))
+		opts.ErrorStream.Write([]byte("err\n"))
+		return nil
+	}
+
+	var out bytes.Buffer
+	if err := s.StreamLogs(context.Background(), "web", 0, LogOptions{}, &out); err != nil {
+		t.Fatalf("StreamLogs() = %v, want %v", err, nil)
+	}
+
+	if out.String() != "out\nerr\n" {
+		t.Errorf("StreamLogs() = %q, want %q", out.String(), "out\nerr\n")
+	}
+}
+
+func TestStreamLogsNoContainer(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		t.Errorf("Logs() called for a missing container")
+		return nil
+	}
+
+	var out bytes.Buffer
+	if err := s.StreamLogs(context.Background(), "web", 3, LogOptions{}, &out); err == nil {
+		t.Errorf("StreamLogs() = %v, want an error", err)
+	}
+}
+
+type failingWriter struct{}
+
+var errWrite = errors.New("write failed")
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	return 0, errWrite
+}
+
+func TestStreamLogsWriterError(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		for {
+			if _, err := opts.OutputStream.Write([]byte("out\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.StreamLogs(context.Background(), "web", 0, LogOptions{Follow: true}, failingWriter{}); err != errWrite {
+		t.Errorf("StreamLogs() = %v, want %v", err, errWrite)
+	}
+}
+
+func TestStreamLogsCancel(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		opts.OutputStream.Write([]byte("out\n"))
+		cancel()
+
+		// a quiet container: no more output, only the canceled context, like
+		// the docker client closing its connection
+		<-opts.Context.Done()
+		return opts.Context.Err()
+	}
+
+	var out bytes.Buffer
+	if err := s.StreamLogs(ctx, "web", 0, LogOptions{Follow: true}, &out); err != nil {
+		t.Errorf("StreamLogs() = %v, want %v", err, nil)
+	}
+
+	if out.String() != "out\n" {
+		t.Errorf("StreamLogs() = %q, want %q", out.String(), "out\n")
+	}
+}
+
+func TestStreamLogsUnreadableDriver(t *testing.T) {
+	s, client := newTestRuntime()
+	syslog := client.addContainer("aaa", "web", "web:1", 0)
+	syslog.HostConfig.LogConfig = docker.LogConfig{Type: "syslog"}
+	jsonFile := client.addContainer("bbb", "web", "web:1", 1)
+	jsonFile.HostConfig.LogConfig = docker.LogConfig{Type: "json-file"}
+
+	client.LogsFunc = func(opts docker.LogsOptions) error {
+		if opts.Container != "bbb" {
+			t.Errorf("Logs() called for %s's %s log driver", opts.Container, "syslog")
+		}
+		return nil
+	}
+
+	var out bytes.Buffer
+	err := s.StreamLogs(context.Background(), "web", 0, LogOptions{}, &out)
+	if e, ok := err.(*LogDriverError); !ok || e.Driver != "syslog" {
+		t.Errorf("StreamLogs() = %v, want a *LogDriverError for %s", err, "syslog")
+	}
+
+	if err := s.StreamLogs(context.Background(), "web", 1, LogOptions{}, &out); err != nil {
+		t.Errorf("StreamLogs() json-file = %v, want %v", err, nil)
+	}
+}
@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives counters and timings from the runtime, so they can be sent
+// on to a metrics backend. The runtime reports:
+//
+//   image.pull.success, image.pull.failure, image.pull.retry
+//   image.pull (duration of a successful pull)
+//   image.prune (an old image tag was removed)
+//   container.start, container.start.failure
+//   container.stop, container.stop.failure
+//   container.stop (duration of a successful stop)
+//   container.blacklist (a stop timed out)
+//   container.reap (an exited container was removed)
+//
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	IncrCounter(name string)
+	ObserveDuration(name string, d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncrCounter(name string)                      {}
+func (nopMetrics) ObserveDuration(name string, d time.Duration) {}
+
+// MemoryMetrics keeps the metrics it receives in memory.
+type MemoryMetrics struct {
+	sync.Mutex
+	Counters  map[string]int
+	Durations map[string][]time.Duration
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		Counters:  make(map[string]int),
+		Durations: make(map[string][]time.Duration),
+	}
+}
+
+func (m *MemoryMetrics) IncrCounter(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.Counters[name]++
+}
+
+func (m *MemoryMetrics) ObserveDuration(name string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.Durations[name] = append(m.Durations[name], d)
+}
+
+// Counter returns the current value of a counter.
+func (m *MemoryMetrics) Counter(name string) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.Counters[name]
+}
+
+func (s *ServiceRuntime) metrics() Metrics {
+	if s.Metrics == nil {
+		return nopMetrics{}
+	}
+	return s.Metrics
+}
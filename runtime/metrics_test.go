@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+)
+
+func assertCounters(t *testing.T, m *MemoryMetrics, want map[string]int) {
+	for name, count := range want {
+		if got := m.Counter(name); got != count {
+			t.Errorf("counter %s = %d, want %d", name, got, count)
+		}
+	}
+}
+
+func TestMetricsStart(t *testing.T) {
+	s, client := newTestRuntime()
+	metrics := NewMemoryMetrics()
+	s.Metrics = metrics
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	if _, err := s.Start("dev", "web", appCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	client.StartContainerFunc = func(id string, hostConfig *docker.HostConfig) error {
+		return errors.New("no space left on device")
+	}
+	if _, err := s.Start("dev", "web", appCfg); err == nil {
+		t.Fatalf("Start() = %v, want an error", err)
+	}
+
+	assertCounters(t, metrics, map[string]int{"container.start": 1, "container.start.failure": 1})
+}
+
+func TestMetricsStop(t *testing.T) {
+	buffer := stopWatchdogBuffer
+	stopWatchdogBuffer = 10 * time.Millisecond
+	defer func() { stopWatchdogBuffer = buffer }()
+
+	s, client := newTestRuntime()
+	metrics := NewMemoryMetrics()
+	s.Metrics = metrics
+
+	stopped := client.addContainer("stopped_container", "web", "1", 0)
+	failed := client.addContainer("failed_container", "web", "1", 1)
+	zombie := client.addContainer("zombie_container", "web", "1", 2)
+	zombie.Config.Env = append(zombie.Config.Env, "GALAXY_STOP_TIMEOUT=1")
+	defer blacklistedContainers.remove(zombie.ID)
+
+	hung := make(chan struct{})
+	defer close(hung)
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		switch id {
+		case failed.ID:
+			return errors.New("docker is down")
+		case zombie.ID:
+			<-hung
+		}
+		return nil
+	}
+
+	for _, c := range []*docker.Container{stopped, failed, zombie} {
+		s.stopContainer(c)
+	}
+
+	assertCounters(t, metrics, map[string]int{
+		"container.stop":         1,
+		"container.stop.failure": 1,
+		"container.blacklist":    1,
+	})
+
+	if len(metrics.Durations["container.stop"]) != 1 {
+		t.Errorf("container.stop durations = %v, want 1", metrics.Durations["container.stop"])
+	}
+}
+
+func TestMetricsPull(t *testing.T) {
+	s, client := newTestRuntime()
+	metrics := NewMemoryMetrics()
+	s.Metrics = metrics
+
+	pulls := 0
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		pulls++
+		if opts.Repository == "broken" || pulls == 1 {
+			return errors.New("connection reset")
+		}
+		client.images = append(client.images, &docker.Image{ID: opts.Repository + ":" + opts.Tag})
+		return nil
+	}
+
+	// fails once, then succeeds
+	if _, err := s.PullImage("web:1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.PullImage("broken:1", ""); err == nil {
+		t.Fatalf("PullImage() = %v, want an error", err)
+	}
+
+	assertCounters(t, metrics, map[string]int{
+		"image.pull.success": 1,
+		"image.pull.failure": 1,
+		"image.pull.retry":   4,
+	})
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+	"github.com/litl/galaxy/log"
+)
+
+// runPreStart runs the app's pre-start hook for pool, if it has one, in a
+// throwaway container from image. The hook gets the same env and network as
+// the service container. A hook that exits non-zero returns a *PreStartError.
+func (s *ServiceRuntime) runPreStart(appCfg config.App, pool, image string, envVars []string, network string) error {
+	hook := appCfg.GetPreStart(pool)
+	if len(hook) == 0 {
+		return nil
+	}
+
+	log.Printf("Running pre-start hook for %s version %s: %s", appCfg.Name(), appCfg.Version(), strings.Join(hook, " "))
+	container, err := s.dockerClient.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:        image,
+			Env:          envVars,
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd:          hook,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	hostConfig := &docker.HostConfig{NetworkMode: network}
+	if s.dns != "" && networkAllowsDNS(network) {
+		hostConfig.DNS = []string{s.dns}
+	}
+
+	stdout, stderr, exitCode, err := s.runCaptured(container, hostConfig)
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		if line != "" {
+			log.Printf("%s pre-start: %s", appCfg.Name(), line)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return &PreStartError{
+			App:      appCfg.Name(),
+			Version:  appCfg.Version(),
+			ExitCode: exitCode,
+			Stderr:   string(stderr),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+)
+
+func TestStartPreStart(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.EnvSet("DATABASE_URL", "postgres://db/web")
+	appCfg.SetPreStart("web", []string{"manage", "migrate"})
+
+	var hook *docker.Container
+	client.WaitContainerFunc = func(id string) (int, error) {
+		hook, _ = client.InspectContainer(id)
+		return 0, nil
+	}
+
+	container, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatalf("Start() = %v, want %v", err, nil)
+	}
+
+	if hook == nil {
+		t.Fatalf("Start() didn't run the pre-start hook")
+	}
+
+	if !reflect.DeepEqual(hook.Config.Cmd, []string{"manage", "migrate"}) || hook.Config.Image != container.Config.Image {
+		t.Errorf("pre-start hook = %s %v, want %s %v", hook.Config.Image, hook.Config.Cmd, container.Config.Image, []string{"manage", "migrate"})
+	}
+
+	if !reflect.DeepEqual(hook.Config.Env, container.Config.Env) {
+		t.Errorf("pre-start hook env = %v, want %v", hook.Config.Env, container.Config.Env)
+	}
+
+	// the hook container is removed once it exits
+	if len(client.containers) != 1 || client.containers[0] != container {
+		t.Errorf("Start() left %d containers, want only %s", len(client.containers), container.ID)
+	}
+}
+
+func TestStartPreStartFails(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+	running := client.addContainer("aaa", "web", "web:0", 0)
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetPreStart("web", []string{"manage", "migrate"})
+
+	client.AttachToContainerFunc = func(opts docker.AttachToContainerOptions) error {
+		opts.ErrorStream.Write([]byte("migration failed\n"))
+		return nil
+	}
+	client.WaitContainerFunc = func(id string) (int, error) {
+		return 3, nil
+	}
+
+	_, err := s.Start("dev", "web", appCfg)
+	preStartErr, ok := err.(*PreStartError)
+	if !ok {
+		t.Fatalf("Start() = %v, want a *PreStartError", err)
+	}
+
+	if preStartErr.ExitCode != 3 || preStartErr.Stderr != "migration failed\n" {
+		t.Errorf("Start() = exit %d stderr %q, want %d %q", preStartErr.ExitCode, preStartErr.Stderr, 3, "migration failed\n")
+	}
+
+	// nothing is done to the service
+	if len(client.containers) != 1 || client.containers[0] != running || !running.State.Running {
+		t.Errorf("Start() left %d containers, want only %s running", len(client.containers), running.ID)
+	}
+}
+
+func TestStartPreStartExistingContainer(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetVersionID("web:1")
+	appCfg.SetPreStart("web", []string{"manage", "migrate"})
+
+	hooks := 0
+	client.WaitContainerFunc = func(id string) (int, error) {
+		hooks++
+		return 0, nil
+	}
+
+	started, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatalf("Start() = %v, want %v", err, nil)
+	}
+
+	// the current container is already running
+	if restarted, running, err := s.StartIfNotRunning("dev", "web", appCfg); restarted || running != started || err != nil {
+		t.Fatalf("StartIfNotRunning() = %t, %v, %v, want %t, %s, %v", restarted, running, err, false, started.ID, nil)
+	}
+
+	// a stopped container of the same image is started again, not re-created
+	if err := s.Stop(appCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if restarted, again, err := s.StartIfNotRunning("dev", "web", appCfg); !restarted || again != started || err != nil {
+		t.Fatalf("StartIfNotRunning() after Stop = %t, %v, %v, want %t, %s, %v", restarted, again, err, true, started.ID, nil)
+	}
+
+	if hooks != 1 {
+		t.Errorf("pre-start hook ran %d times, want only %d for the created container", hooks, 1)
+	}
+}
+
+func TestStartNoPreStart(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	client.WaitContainerFunc = func(id string) (int, error) {
+		t.Errorf("WaitContainer(%s) called without a pre-start hook", id)
+		return 0, nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	if _, err := s.Start("dev", "web", appCfg); err != nil {
+		t.Fatalf("Start() = %v, want %v", err, nil)
+	}
+
+	if client.created != 1 {
+		t.Errorf("Start() created %d containers, want %d", client.created, 1)
+	}
+}
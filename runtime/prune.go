@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+	"github.com/litl/galaxy/utils"
+)
+
+// PruneImages removes old images of the galaxy apps with containers on this
+// host. For each app, the keepVersions most recently created images of its
+// repository are kept, along with any image used by a container, running or
+// not. Removal errors are logged and the rest of the images are still tried;
+// the first error other than the image being in use is returned.
+func (s *ServiceRuntime) PruneImages(keepVersions int) error {
+	if keepVersions < 0 {
+		return fmt.Errorf("can't keep %d image versions", keepVersions)
+	}
+
+	inUse, repos, err := s.imageUsage()
+	if err != nil {
+		return err
+	}
+
+	images, err := s.dockerClient.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		return err
+	}
+
+	// newest first, so the kept versions come first in each repository
+	sort.Sort(imagesByCreated(images))
+
+	keep := map[string]bool{}
+	kept := map[string]int{}
+	for _, image := range images {
+		for _, repoTag := range image.RepoTags {
+			repo := tagRepository(repoTag)
+			if !repos[repo] || keep[image.ID] {
+				continue
+			}
+			if kept[repo] < keepVersions {
+				kept[repo]++
+				keep[image.ID] = true
+			}
+		}
+	}
+
+	var firstErr error
+	for _, image := range images {
+		if keep[image.ID] || inUse[image.ID] {
+			continue
+		}
+
+		// only untag the app's repositories. The image is removed along
+		// with its last tag.
+		for _, repoTag := range image.RepoTags {
+			if !repos[tagRepository(repoTag)] {
+				continue
+			}
+
+			err := s.dockerClient.RemoveImage(repoTag)
+			if err == nil {
+				log.Printf("Removed image %s", repoTag)
+				s.metrics().IncrCounter("image.prune")
+				continue
+			}
+
+			if imageInUse(err) || err == docker.ErrNoSuchImage {
+				log.Warnf("WARN: Not removing image %s: %s", repoTag, err)
+				continue
+			}
+
+			log.Errorf("ERROR: Unable to remove image %s: %s", repoTag, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// imageUsage returns the IDs of the images used by containers on this host,
+// and the image repositories of the galaxy apps among them.
+func (s *ServiceRuntime) imageUsage() (map[string]bool, map[string]bool, error) {
+	containers, err := s.dockerClient.ListContainers(docker.ListContainersOptions{
+		All: true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inUse := map[string]bool{}
+	repos := map[string]bool{}
+	for _, c := range containers {
+		container, err := s.dockerClient.InspectContainer(c.ID)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			continue
+		}
+		// without knowing what it runs, nothing is safe to remove
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inUse[container.Image] = true
+		if s.EnvFor(container)["GALAXY_APP"] != "" {
+			registry, repository, _, _ := utils.SplitDockerImage(container.Config.Image)
+			repos[repositoryWithRegistry(registry, repository)] = true
+		}
+	}
+	return inUse, repos, nil
+}
+
+// tagRepository strips the tag from a repo tag like registry:5000/web:1
+func tagRepository(repoTag string) string {
+	if i := strings.LastIndex(repoTag, ":"); i > strings.LastIndex(repoTag, "/") {
+		return repoTag[:i]
+	}
+	return repoTag
+}
+
+// imageInUse is true if docker refused to remove an image because a
+// container or another image still needs it.
+func imageInUse(err error) bool {
+	if e, ok := err.(*docker.Error); ok && e.Status == 409 {
+		return true
+	}
+	return strings.Contains(err.Error(), "is being used")
+}
+
+type imagesByCreated []docker.APIImages
+
+func (a imagesByCreated) Len() int           { return len(a) }
+func (a imagesByCreated) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a imagesByCreated) Less(i, j int) bool { return a[i].Created > a[j].Created }
@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// pruneRuntime runs web:4 and a non-galaxy container on redis, with an
+// exited web:2 container still around. web:1 through web:5 are pulled.
+func pruneRuntime() (*ServiceRuntime, *fakeDocker, *[]string) {
+	s, client := newTestRuntime()
+	client.addContainer("web_4_container", "web", "web:4", 0).Image = "img4"
+	exited := client.addContainer("web_2_container", "web", "web:2", 1)
+	exited.Image = "img2"
+	exited.State.Running = false
+	redis := client.addContainer("redis_container", "", "redis:3", 0)
+	redis.Config.Env = nil
+	redis.Image = "redis3"
+
+	client.ListImagesFunc = func(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+		return []docker.APIImages{
+			{ID: "img1", RepoTags: []string{"web:1"}, Created: 1},
+			{ID: "img3", RepoTags: []string{"web:3"}, Created: 3},
+			{ID: "img2", RepoTags: []string{"web:2"}, Created: 2},
+			{ID: "img5", RepoTags: []string{"web:5"}, Created: 5},
+			{ID: "img4", RepoTags: []string{"web:4"}, Created: 4},
+			{ID: "redis2", RepoTags: []string{"redis:2"}, Created: 1},
+			{ID: "redis3", RepoTags: []string{"redis:3"}, Created: 2},
+		}, nil
+	}
+
+	removed := []string{}
+	client.RemoveImageFunc = func(name string) error {
+		removed = append(removed, name)
+		return nil
+	}
+	return s, client, &removed
+}
+
+func TestPruneImages(t *testing.T) {
+	s, _, removed := pruneRuntime()
+
+	if err := s.PruneImages(1); err != nil {
+		t.Fatalf("PruneImages() = %v, want %v", err, nil)
+	}
+
+	// web:5 is the newest, web:4 and web:2 are used, and redis isn't an app
+	sort.Strings(*removed)
+	if want := []string{"web:1", "web:3"}; !reflect.DeepEqual(*removed, want) {
+		t.Errorf("PruneImages(1) removed %v, want %v", *removed, want)
+	}
+}
+
+func TestPruneImagesKeepsVersions(t *testing.T) {
+	s, _, removed := pruneRuntime()
+
+	if err := s.PruneImages(3); err != nil {
+		t.Fatalf("PruneImages() = %v, want %v", err, nil)
+	}
+
+	if want := []string{"web:1"}; !reflect.DeepEqual(*removed, want) {
+		t.Errorf("PruneImages(3) removed %v, want %v", *removed, want)
+	}
+}
+
+func TestPruneImagesInUse(t *testing.T) {
+	s, client, _ := pruneRuntime()
+
+	removed := []string{}
+	client.RemoveImageFunc = func(name string) error {
+		if name == "web:1" {
+			return &docker.Error{Status: 409, Message: "conflict: image is being used by stopped container"}
+		}
+		removed = append(removed, name)
+		return nil
+	}
+
+	if err := s.PruneImages(0); err != nil {
+		t.Fatalf("PruneImages() = %v, want %v", err, nil)
+	}
+
+	sort.Strings(removed)
+	if want := []string{"web:3", "web:5"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("PruneImages(0) removed %v, want %v", removed, want)
+	}
+}
+
+func TestPruneImagesError(t *testing.T) {
+	s, client, _ := pruneRuntime()
+
+	removeErr := errors.New("connection reset")
+	tried := 0
+	client.RemoveImageFunc = func(name string) error {
+		tried++
+		return removeErr
+	}
+
+	if err := s.PruneImages(0); err != removeErr {
+		t.Errorf("PruneImages() = %v, want %v", err, removeErr)
+	}
+
+	if tried != 3 {
+		t.Errorf("PruneImages() tried %d images, want %d", tried, 3)
+	}
+}
+
+func TestPruneImagesInspectError(t *testing.T) {
+	s, client, removed := pruneRuntime()
+	client.InspectContainerFunc = func(id string) (*docker.Container, error) {
+		return nil, errors.New("timeout")
+	}
+
+	if err := s.PruneImages(0); err == nil {
+		t.Errorf("PruneImages() = %v, want an error", err)
+	}
+
+	if len(*removed) != 0 {
+		t.Errorf("PruneImages() removed %v without knowing what's in use", *removed)
+	}
+}
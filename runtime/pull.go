@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+)
+
+// JSONMessage is one message of the docker pull stream, the same as docker's
+// jsonmessage. go-dockerclient doesn't export a type for it.
+type JSONMessage struct {
+	// Status is the pull step, e.g. "Downloading" or "Pull complete"
+	Status string `json:"status,omitempty"`
+	// ID is the layer the message is about, if any
+	ID       string        `json:"id,omitempty"`
+	Progress *JSONProgress `json:"progressDetail,omitempty"`
+	// ProgressMessage is docker's own rendering of the progress bar
+	ProgressMessage string `json:"progress,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// JSONProgress is the byte count of a layer download or extraction
+type JSONProgress struct {
+	Current int64 `json:"current,omitempty"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+// decodePullStream calls onProgress for each message read from r. The
+// docker client doesn't check a raw stream for errors, so an error message
+// fails the pull.
+func decodePullStream(r io.Reader, onProgress func(JSONMessage)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg JSONMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		onProgress(msg)
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// pull runs a single pull, sending progress to onProgress or the log if
+// onProgress is nil.
+func (s *ServiceRuntime) pull(opts docker.PullImageOptions, auth docker.AuthConfiguration, onProgress func(JSONMessage)) error {
+	if onProgress == nil {
+		opts.OutputStream = log.DefaultLogger
+		return s.dockerClient.PullImage(opts, auth)
+	}
+
+	r, w := io.Pipe()
+	decoded := make(chan error, 1)
+	go func() {
+		err := decodePullStream(r, onProgress)
+		// keep reading so the pull isn't blocked after a bad message
+		io.Copy(ioutil.Discard, r)
+		decoded <- err
+	}()
+
+	opts.OutputStream = w
+	opts.RawJSONStream = true
+	err := s.dockerClient.PullImage(opts, auth)
+	w.Close()
+
+	if decodeErr := <-decoded; err == nil {
+		err = decodeErr
+	}
+	return err
+}
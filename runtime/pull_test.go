@@ -0,0 +1,267 @@
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+)
+
+// a pull stream as sent by the docker daemon
+var recordedPull = `{"status":"Pulling from library/web","id":"1"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a1b2c3d4e5f6"}
+{"status":"Downloading","progressDetail":{"current":512,"total":1024},"progress":"[=====>     ] 512 B/1.024 kB","id":"a1b2c3d4e5f6"}
+{"status":"Pull complete","progressDetail":{},"id":"a1b2c3d4e5f6"}
+{"status":"Status: Downloaded newer image for web:1"}
+`
+
+func TestPullImageProgress(t *testing.T) {
+	s, client := newTestRuntime()
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		if !opts.RawJSONStream {
+			t.Errorf("PullImage() RawJSONStream = %t, want %t", opts.RawJSONStream, true)
+		}
+		opts.OutputStream.Write([]byte(recordedPull))
+		client.images = append(client.images, &docker.Image{ID: "web:1"})
+		return nil
+	}
+
+	messages := []JSONMessage{}
+	image, err := s.PullImageProgress("web:1", "", func(msg JSONMessage) {
+		messages = append(messages, msg)
+	})
+	if err != nil {
+		t.Fatalf("PullImageProgress() = %v, want %v", err, nil)
+	}
+
+	if image == nil || image.ID != "web:1" {
+		t.Errorf("PullImageProgress() = %v, want image %s", image, "web:1")
+	}
+
+	want := []JSONMessage{
+		{Status: "Pulling from library/web", ID: "1"},
+		{Status: "Pulling fs layer", ID: "a1b2c3d4e5f6", Progress: &JSONProgress{}},
+		{Status: "Downloading", ID: "a1b2c3d4e5f6", Progress: &JSONProgress{Current: 512, Total: 1024}, ProgressMessage: "[=====>     ] 512 B/1.024 kB"},
+		{Status: "Pull complete", ID: "a1b2c3d4e5f6", Progress: &JSONProgress{}},
+		{Status: "Status: Downloaded newer image for web:1"},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("PullImageProgress() messages = %+v, want %+v", messages, want)
+	}
+}
+
+func TestPullImageProgressError(t *testing.T) {
+	s, client := newTestRuntime()
+
+	pulls := 0
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		pulls++
+		opts.OutputStream.Write([]byte(`{"status":"Pulling from library/web","id":"1"}` + "\n"))
+		opts.OutputStream.Write([]byte(`{"error":"unauthorized"}` + "\n"))
+		// the daemon may keep writing after the error
+		opts.OutputStream.Write([]byte(`{"status":"more"}` + "\n"))
+		return nil
+	}
+
+	failed := 0
+	_, err := s.PullImageProgress("web:1", "", func(msg JSONMessage) {
+		if msg.Error != "" {
+			failed++
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("PullImageProgress() = %v, want unauthorized", err)
+	}
+
+	// retried, like any other pull error
+	if pulls != failed || pulls < 2 {
+		t.Errorf("PullImageProgress() pulled %d times with %d errors, want retries", pulls, failed)
+	}
+}
+
+func TestPullImageLogsProgress(t *testing.T) {
+	s, client := newTestRuntime()
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		if opts.OutputStream != log.DefaultLogger || opts.RawJSONStream {
+			t.Errorf("PullImage() output = %v raw %t, want the default logger", opts.OutputStream, opts.RawJSONStream)
+		}
+		return nil
+	}
+
+	s.PullImage("web:1", "")
+}
+
+// staleTagRuntime has web:1 tagged locally as img1
+func staleTagRuntime() (*ServiceRuntime, *fakeDocker, map[string]string) {
+	s, client := newTestRuntime()
+	tags := map[string]string{"web:1": "img1"}
+	client.InspectImageFunc = func(name string) (*docker.Image, error) {
+		if id, ok := tags[name]; ok {
+			return &docker.Image{ID: id}, nil
+		}
+		return nil, docker.ErrNoSuchImage
+	}
+	return s, client, tags
+}
+
+func TestPullImageStaleTag(t *testing.T) {
+	s, client, _ := staleTagRuntime()
+
+	pulls := 0
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		// the registry still has the old build
+		pulls++
+		return nil
+	}
+
+	image, err := s.PullImage("web:1", "img2")
+	if err == nil {
+		t.Errorf("PullImage() = %v, want an error", image)
+	}
+
+	if pulls != 1 {
+		t.Errorf("PullImage() pulled %d times, want %d", pulls, 1)
+	}
+}
+
+func TestPullImageMovesStaleTag(t *testing.T) {
+	s, client, tags := staleTagRuntime()
+
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		tags["web:1"] = "img2"
+		return nil
+	}
+
+	image, err := s.PullImage("web:1", "img2")
+	if err != nil {
+		t.Fatalf("PullImage() = %v, want %v", err, nil)
+	}
+
+	if image.ID != "img2" {
+		t.Errorf("PullImage() = %s, want %s", image.ID, "img2")
+	}
+}
+
+func TestPullImageStaleTagImageID(t *testing.T) {
+	s, client, tags := staleTagRuntime()
+	old := "sha256:1f0a7e3c9d5b2a4e6c8f0b1d3a5c7e9f2b4d6a8c0e1f3b5d7a9c2e4f6b8d0a1c"
+	want := "sha256:9a8f4c3e1d2b7a6c5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a"
+	tags["web:1"] = old
+
+	pulls := 0
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		// the registry still has the old build
+		pulls++
+		return nil
+	}
+
+	// docker's image IDs are sha256 ids too, and are still checked
+	image, err := s.PullImage("web:1", want)
+	if _, ok := err.(*ImageMismatchError); !ok {
+		t.Errorf("PullImage() = %v, %v, want an *ImageMismatchError", image, err)
+	}
+
+	if pulls != 1 {
+		t.Errorf("PullImage() pulled %d times, want %d", pulls, 1)
+	}
+
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		tags["web:1"] = want
+		return nil
+	}
+
+	if image, err := s.PullImage("web:1", want); err != nil || image.ID != want {
+		t.Errorf("PullImage() after the tag moved = %v, %v, want %s", image, err, want)
+	}
+}
+
+func TestPullImageStaleTagNotFound(t *testing.T) {
+	s, client, _ := staleTagRuntime()
+
+	client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+		return &docker.Error{Status: 404, Message: "not found"}
+	}
+
+	// the old image mustn't be used in place of the missing one
+	if image, err := s.PullImage("web:1", "img2"); err == nil {
+		t.Errorf("PullImage() = %v, want an error", image)
+	}
+}
+
+func TestPullImageReferences(t *testing.T) {
+	digest := "sha256:0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2"
+
+	for _, tc := range []struct {
+		version string
+		want    docker.PullImageOptions
+	}{
+		{"web", docker.PullImageOptions{Repository: "web", Tag: "latest"}},
+		{"myhost:5000/web:1", docker.PullImageOptions{Repository: "myhost:5000/web", Registry: "myhost:5000", Tag: "1"}},
+		{"myhost:5000/team/web", docker.PullImageOptions{Repository: "myhost:5000/team/web", Registry: "myhost:5000", Tag: "latest"}},
+		{"myhost:5000/web@" + digest, docker.PullImageOptions{Repository: "myhost:5000/web", Registry: "myhost:5000", Tag: digest}},
+	} {
+		s, client := newTestRuntime()
+
+		var pulled docker.PullImageOptions
+		client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+			pulled = opts
+			client.images = append(client.images, &docker.Image{ID: tc.version})
+			return nil
+		}
+
+		if _, err := s.PullImage(tc.version, ""); err != nil {
+			t.Fatalf("PullImage(%s) = %v, want %v", tc.version, err, nil)
+		}
+
+		pulled.OutputStream = nil
+		if !reflect.DeepEqual(pulled, tc.want) {
+			t.Errorf("PullImage(%s) pulled %+v, want %+v", tc.version, pulled, tc.want)
+		}
+	}
+}
+
+func TestPullImageTagOrDigest(t *testing.T) {
+	digest := "sha256:0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2"
+	imageID := "sha256:9a8f4c3e1d2b7a6c5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a"
+
+	for _, tc := range []struct {
+		version string
+		// what the registry has the version as
+		pulled string
+		tag    string
+	}{
+		// an image ID isn't a manifest digest, so it's pulled by tag
+		{"web:1", "web:1", "1"},
+		{"web@" + digest, "web@" + digest, digest},
+	} {
+		s, client := newTestRuntime()
+
+		pulls := []string{}
+		client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+			pulls = append(pulls, opts.Tag)
+			client.images = append(client.images, &docker.Image{ID: tc.pulled})
+			return nil
+		}
+		client.InspectImageFunc = func(name string) (*docker.Image, error) {
+			if len(client.images) == 0 || name != tc.pulled {
+				return nil, docker.ErrNoSuchImage
+			}
+			return &docker.Image{ID: imageID}, nil
+		}
+
+		image, err := s.PullImage(tc.version, imageID)
+		if err != nil {
+			t.Fatalf("PullImage(%s, %s) = %v, want %v", tc.version, imageID, err, nil)
+		}
+
+		if image.ID != imageID {
+			t.Errorf("PullImage(%s, %s) = %s, want %s", tc.version, imageID, image.ID, imageID)
+		}
+
+		if !reflect.DeepEqual(pulls, []string{tc.tag}) {
+			t.Errorf("PullImage(%s, %s) pulled %v, want %v", tc.version, imageID, pulls, []string{tc.tag})
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+)
+
+// ReapExited removes galaxy containers, and their volumes, that exited more
+// than olderThan ago, and returns their IDs. Running, restarting, paused and
+// blacklisted containers are never removed. A failed removal doesn't stop the
+// others, and the first error is returned.
+func (s *ServiceRuntime) ReapExited(olderThan time.Duration) ([]string, error) {
+	containers, err := s.dockerClient.ListContainers(docker.ListContainersOptions{
+		All: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	removed := []string{}
+	var firstErr error
+	for _, c := range containers {
+		container, err := s.dockerClient.InspectContainer(c.ID)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			continue
+		}
+		if err != nil {
+			log.Errorf("ERROR: Unable to inspect container: %s: %s", c.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if !s.reapable(container, cutoff) {
+			continue
+		}
+
+		err = s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+			ID:            container.ID,
+			RemoveVolumes: true,
+		})
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			continue
+		}
+		if err != nil {
+			log.Errorf("ERROR: Unable to remove exited container %s: %s", container.ID[:12], err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		log.Printf("Removed exited %s container %s", s.EnvFor(container)["GALAXY_APP"], container.ID[:12])
+		s.metrics().IncrCounter("container.reap")
+		removed = append(removed, container.ID)
+	}
+	return removed, firstErr
+}
+
+// reapable is true for a galaxy container that finished before cutoff
+func (s *ServiceRuntime) reapable(container *docker.Container, cutoff time.Time) bool {
+	if s.EnvFor(container)["GALAXY_APP"] == "" {
+		return false
+	}
+
+	state := container.State
+	if state.Running || state.Restarting || state.Paused {
+		return false
+	}
+
+	// never started, or docker doesn't know when it exited
+	if state.FinishedAt.IsZero() || state.FinishedAt.After(cutoff) {
+		return false
+	}
+
+	return !blacklistedContainers.contains(container.ID)
+}
@@ -0,0 +1,88 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// addExitedContainer adds a galaxy container that exited age ago
+func (f *fakeDocker) addExitedContainer(id string, age time.Duration) *docker.Container {
+	container := f.addContainer(id, "web", "1", 0)
+	container.State = docker.State{
+		ExitCode:   1,
+		FinishedAt: time.Now().Add(-age),
+	}
+	return container
+}
+
+func TestReapExited(t *testing.T) {
+	s, client := newTestRuntime()
+	defer s.ClearAllBlacklisted()
+
+	client.addContainer("running_container", "web", "1", 0)
+	client.addContainer("restarting_container", "web", "1", 1).State = docker.State{
+		Restarting: true,
+		FinishedAt: time.Now().Add(-time.Hour),
+	}
+	old := client.addExitedContainer("old_exited_container", time.Hour)
+	client.addExitedContainer("new_exited_container", time.Minute)
+	client.addExitedContainer("zombie_container", time.Hour)
+	blacklistedContainers.add("zombie_container")
+
+	other := client.addExitedContainer("other_exited_container", time.Hour)
+	other.Config.Env = nil
+
+	never := client.addContainer("created_container", "web", "1", 2)
+	never.State = docker.State{}
+
+	client.RemoveContainerFunc = func(opts docker.RemoveContainerOptions) error {
+		if !opts.RemoveVolumes {
+			t.Errorf("RemoveContainer(%s) RemoveVolumes = %t, want %t", opts.ID, opts.RemoveVolumes, true)
+		}
+		if opts.ID != old.ID {
+			t.Errorf("RemoveContainer(%s) called, only %s should be removed", opts.ID, old.ID)
+		}
+		return nil
+	}
+
+	removed, err := s.ReapExited(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("ReapExited() = %v, want %v", err, nil)
+	}
+
+	if want := []string{old.ID}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("ReapExited() = %v, want %v", removed, want)
+	}
+}
+
+func TestReapExitedRemoveError(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addExitedContainer("failing_container", time.Hour)
+	client.addExitedContainer("gone_container", time.Hour)
+	ok := client.addExitedContainer("exited_container", time.Hour)
+
+	removeErr := errors.New("device or resource busy")
+	client.RemoveContainerFunc = func(opts docker.RemoveContainerOptions) error {
+		switch opts.ID {
+		case "failing_container":
+			return removeErr
+		case "gone_container":
+			return &docker.NoSuchContainer{ID: opts.ID}
+		}
+		return nil
+	}
+
+	removed, err := s.ReapExited(time.Minute)
+	if err != removeErr {
+		t.Errorf("ReapExited() = %v, want %v", err, removeErr)
+	}
+
+	// the failure doesn't stop the rest from being reaped
+	if want := []string{ok.ID}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("ReapExited() = %v, want %v", removed, want)
+	}
+}
@@ -1,6 +1,8 @@
 package runtime
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -8,8 +10,12 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -18,26 +24,135 @@ import (
 	"github.com/litl/galaxy/utils"
 )
 
-var blacklistedContainerId = make(map[string]bool)
+var blacklistedContainers = newBlacklist()
+
+// Seconds docker waits for a container to exit after SIGTERM, if the app
+// doesn't set its own stop timeout.
+const defaultStopTimeout = 10
+
+// How much longer than the stop timeout to wait on docker before giving up on
+// a container and blacklisting it.
+var stopWatchdogBuffer = 10 * time.Second
+
+// Docker client timeouts used by NewServiceRuntime if none are given. Pings
+// fail fast, so a hung daemon is noticed without waiting on the much longer
+// timeout pulls need.
+const (
+	DefaultDockerTimeout = 60 * time.Second
+	DefaultPingTimeout   = 5 * time.Second
+)
+
+// DefaultContainerNameTemplate names containers after the app's config
+// version and instance, e.g. web_12.0
+const DefaultContainerNameTemplate = "{{.ContainerName}}.{{.Instance}}"
+
+// ContainerNameData is what a container name template can refer to
+type ContainerNameData struct {
+	Env  string
+	Pool string
+	App  string
+	// the app's config version
+	Version int64
+	// the app name and config version, e.g. web_12
+	ContainerName string
+	Instance      int
+}
+
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
 
 // the deafult docker index server
 var defaultIndexServer = "https://index.docker.io/v1/"
 
+// dockerAuths loads the registry credentials, normally from ~/.dockercfg
+var dockerAuths = docker.NewAuthConfigurationsFromDockerCfg
+
+// dockerAPI is the part of *docker.Client used by the runtime, so tests can
+// substitute a fake client.
+type dockerAPI interface {
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	AttachToContainer(opts docker.AttachToContainerOptions) error
+	ConnectNetwork(id string, opts docker.NetworkConnectionOptions) error
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	InspectContainer(id string) (*docker.Container, error)
+	InspectImage(name string) (*docker.Image, error)
+	KillContainer(opts docker.KillContainerOptions) error
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	Logs(opts docker.LogsOptions) error
+	NetworkInfo(id string) (*docker.Network, error)
+	PingWithContext(ctx context.Context) error
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	RemoveImage(name string) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	Stats(opts docker.StatsOptions) error
+	StopContainer(id string, timeout uint) error
+	TagImage(name string, opts docker.TagImageOptions) error
+	WaitContainer(id string) (int, error)
+}
+
 type ServiceRuntime struct {
-	dockerClient *docker.Client
+	dockerClient dockerAPI
 	dns          string
 	configStore  *config.Store
 	dockerIP     string
 	hostIP       string
+
+	// how long Ping waits for docker
+	pingTimeout time.Duration
+
+	// registryMirror is the registry host used for images that don't name
+	// one, instead of the docker index.
+	registryMirror string
+
+	// IndexServer is the auth config key used when there are no credentials
+	// for an image's registry. Defaults to the docker index.
+	IndexServer string
+
+	// ContainerNameTemplate is the text/template Start names containers
+	// with. DefaultContainerNameTemplate is used if it's empty.
+	ContainerNameTemplate string
+
+	// DryRun logs the containers that would be stopped, without stopping
+	// them.
+	DryRun bool
+
+	// Metrics receives counters for pulls, starts and stops. Nothing is
+	// reported if it's nil.
+	Metrics Metrics
+
+	// instance slots taken by containers that are still being started
+	reserved slotReservations
 }
 
 type ContainerEvent struct {
 	Status              string
 	Container           *docker.Container
 	ServiceRegistration *config.ServiceRegistration
+	// Set from the container state once it has exited
+	ExitCode  int
+	OOMKilled bool
 }
 
-func NewServiceRuntime(configStore *config.Store, dns, hostIP string) *ServiceRuntime {
+// publicHostname looks up the host's public name for PUBLIC_HOSTNAME
+var publicHostname = EC2PublicHostname
+
+func lookupPublicHostname() string {
+	publicDns, err := publicHostname()
+	if err != nil {
+		log.Warnf("Unable to determine public hostname. Not on AWS? %s", err)
+		return "127.0.0.1"
+	}
+	return publicDns
+}
+
+// NewServiceRuntime returns a runtime using the local docker daemon. If
+// registryMirror is set, images without an explicit registry are pulled from
+// that host, e.g. a pull-through cache of the docker index. timeout limits
+// each docker API call, and pingTimeout limits the liveness pings. The
+// defaults are used for timeouts of 0.
+func NewServiceRuntime(configStore *config.Store, dns, hostIP, registryMirror string, timeout, pingTimeout time.Duration) *ServiceRuntime {
 	var err error
 	var client *docker.Client
 
@@ -48,10 +163,10 @@ func NewServiceRuntime(configStore *config.Store, dns, hostIP string) *ServiceRu
 
 	endpoint := GetEndpoint()
 
-	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
-		cert := certPath + "/cert.pem"
-		key := certPath + "/key.pem"
-		ca := certPath + "/ca.pem"
+	if certPath := dockerCertPath(os.Getenv); certPath != "" {
+		cert := filepath.Join(certPath, "cert.pem")
+		key := filepath.Join(certPath, "key.pem")
+		ca := filepath.Join(certPath, "ca.pem")
 		client, err = docker.NewTLSClient(endpoint, cert, key, ca)
 	} else {
 		client, err = docker.NewClient(endpoint)
@@ -61,17 +176,91 @@ func NewServiceRuntime(configStore *config.Store, dns, hostIP string) *ServiceRu
 		log.Fatalf("ERROR: Unable to initialize docker client: %s: %s", err, endpoint)
 	}
 
-	client.HTTPClient.Timeout = 60 * time.Second
+	if timeout == 0 {
+		timeout = DefaultDockerTimeout
+	}
+	client.HTTPClient.Timeout = timeout
 
 	return &ServiceRuntime{
-		dns:          dns,
-		configStore:  configStore,
-		hostIP:       hostIP,
-		dockerIP:     dockerZero,
-		dockerClient: client,
+		dns:            dns,
+		configStore:    configStore,
+		hostIP:         hostIP,
+		dockerIP:       dockerZero,
+		dockerClient:   client,
+		registryMirror: registryHost(registryMirror),
+		pingTimeout:    pingTimeout,
 	}
 }
 
+// ContainerName returns the name Start gives the container for an instance
+// of appCfg in env and pool, from the ContainerNameTemplate.
+func (s *ServiceRuntime) ContainerName(env, pool string, appCfg config.App, instance int) (string, error) {
+	text := s.ContainerNameTemplate
+	if text == "" {
+		text = DefaultContainerNameTemplate
+	}
+
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("bad container name template %q: %s", text, err)
+	}
+
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, ContainerNameData{
+		Env:           env,
+		Pool:          pool,
+		App:           appCfg.Name(),
+		Version:       appCfg.ID(),
+		ContainerName: appCfg.ContainerName(),
+		Instance:      instance,
+	})
+	if err != nil {
+		return "", fmt.Errorf("bad container name template %q: %s", text, err)
+	}
+
+	if !validContainerName.MatchString(name.String()) {
+		return "", fmt.Errorf("invalid container name %q from template %q", name.String(), text)
+	}
+	return name.String(), nil
+}
+
+// tmpfsArgs formats tmpfs mounts as docker run --tmpfs values, sorted by path
+func tmpfsArgs(mounts map[string]string) []string {
+	args := []string{}
+	for path, opts := range mounts {
+		if opts != "" {
+			path += ":" + opts
+		}
+		args = append(args, path)
+	}
+	sort.Strings(args)
+	return args
+}
+
+// registryHost strips the scheme and path from a registry given as a URL,
+// since docker only uses the host in image names.
+func registryHost(registry string) string {
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimRight(registry, "/")
+}
+
+// dockerCertPath returns the directory of the TLS certs for the docker
+// daemon, the way the docker CLI finds them: DOCKER_CERT_PATH, or ~/.docker
+// when only DOCKER_TLS_VERIFY is set. The server cert is verified against the
+// ca.pem there. It's empty if the daemon isn't using TLS.
+func dockerCertPath(getenv func(string) string) string {
+	if certPath := getenv("DOCKER_CERT_PATH"); certPath != "" {
+		return certPath
+	}
+
+	if getenv("DOCKER_TLS_VERIFY") != "" {
+		return filepath.Join(getenv("HOME"), ".docker")
+	}
+	return ""
+}
+
 func GetEndpoint() string {
 	defaultEndpoint := "unix:///var/run/docker.sock"
 	if os.Getenv("DOCKER_HOST") != "" {
@@ -167,8 +356,22 @@ func dockerBridgeIp() (string, error) {
 	return "", errors.New("unable to find docker0 interface")
 }
 
+// Ping checks the docker daemon is answering. It cancels the request and
+// returns ErrPingTimeout after the ping timeout.
 func (s *ServiceRuntime) Ping() error {
-	return s.dockerClient.Ping()
+	timeout := s.pingTimeout
+	if timeout == 0 {
+		timeout = DefaultPingTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.dockerClient.PingWithContext(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return ErrPingTimeout
+	}
+	return err
 }
 
 func (s *ServiceRuntime) InspectImage(image string) (*docker.Image, error) {
@@ -216,27 +419,48 @@ func (s *ServiceRuntime) Stop(appCfg config.App) error {
 	return nil
 }
 
+// stopTimeout returns the stop timeout a container was started with, in
+// GALAXY_STOP_TIMEOUT, or the default for containers started without one.
+func (s *ServiceRuntime) stopTimeout(container *docker.Container) uint {
+	timeout, err := strconv.ParseUint(s.EnvFor(container)["GALAXY_STOP_TIMEOUT"], 10, 32)
+	if err != nil || timeout == 0 {
+		return defaultStopTimeout
+	}
+	return uint(timeout)
+}
+
 func (s *ServiceRuntime) stopContainer(container *docker.Container) error {
-	if _, ok := blacklistedContainerId[container.ID]; ok {
+	if blacklistedContainers.contains(container.ID) {
 		log.Printf("Container %s blacklisted. Won't try to stop.\n", container.ID)
 		return nil
 	}
 
+	if s.DryRun {
+		log.Printf("Would stop %s container %s\n", strings.TrimPrefix(container.Name, "/"), container.ID[0:12])
+		return nil
+	}
+
 	log.Printf("Stopping %s container %s\n", strings.TrimPrefix(container.Name, "/"), container.ID[0:12])
 
+	grace := s.stopTimeout(container)
+	start := time.Now()
 	c := make(chan error, 1)
-	go func() { c <- s.dockerClient.StopContainer(container.ID, 10) }()
+	go func() { c <- s.dockerClient.StopContainer(container.ID, grace) }()
 	select {
 	case err := <-c:
 		if err != nil {
+			s.metrics().IncrCounter("container.stop.failure")
 			log.Printf("ERROR: Unable to stop container: %s\n", container.ID)
 			return err
 		}
-	case <-time.After(20 * time.Second):
-		blacklistedContainerId[container.ID] = true
+	case <-time.After(time.Duration(grace)*time.Second + stopWatchdogBuffer):
+		blacklistedContainers.add(container.ID)
+		s.metrics().IncrCounter("container.blacklist")
 		log.Printf("ERROR: Timed out trying to stop container. Zombie?. Blacklisting: %s\n", container.ID)
-		return nil
+		return ErrContainerStopTimeout
 	}
+	s.metrics().IncrCounter("container.stop")
+	s.metrics().ObserveDuration("container.stop", time.Since(start))
 	log.Printf("Stopped %s container %s\n", strings.TrimPrefix(container.Name, "/"), container.ID[0:12])
 
 	return nil
@@ -248,19 +472,19 @@ func (s *ServiceRuntime) stopContainer(container *docker.Container) error {
 	})*/
 }
 
-func (s *ServiceRuntime) StopOldVersion(appCfg config.App, limit int) error {
+// containersToStop returns the running containers for appCfg that don't match
+// its current config. A container is old if it was started from a different
+// image than the app's VersionID, or with a different config version in
+// GALAXY_VERSION. Either check is skipped when the app or container has no
+// value for it. Blacklisted containers are left out.
+func (s *ServiceRuntime) containersToStop(appCfg config.App) ([]*docker.Container, error) {
 	containers, err := s.ManagedContainers()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	stopped := 0
-
-	for _, container := range containers {
-
-		if stopped == limit {
-			return nil
-		}
+	toStop := []*docker.Container{}
+	for _, container := range notBlacklisted(containers) {
 
 		env := s.EnvFor(container)
 		// Container name does match one that would be started w/ this service config
@@ -286,49 +510,32 @@ func (s *ServiceRuntime) StopOldVersion(appCfg config.App, limit int) error {
 		versionDiffers := version != strconv.FormatInt(appCfg.ID(), 10) && version != ""
 
 		if imageDiffers || versionDiffers {
-			s.stopContainer(container)
-			stopped = stopped + 1
+			toStop = append(toStop, container)
 		}
 	}
-	return nil
+	return toStop, nil
 }
 
-func (s *ServiceRuntime) StopAllButCurrentVersion(appCfg config.App) error {
-	containers, err := s.ManagedContainers()
+// StopOldVersion stops up to limit containers running an old version of
+// appCfg, and returns them. A limit <= 0 stops all of them.
+func (s *ServiceRuntime) StopOldVersion(appCfg config.App, limit int) ([]*docker.Container, error) {
+	containers, err := s.containersToStop(appCfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, container := range containers {
-
-		env := s.EnvFor(container)
-		// Container name does match one that would be started w/ this service config
-		if env["GALAXY_APP"] != appCfg.Name() {
-			continue
-		}
-
-		image, err := s.InspectImage(container.Image)
-		if err != nil {
-			log.Errorf("ERROR: Unable to inspect image: %s", container.Image)
-			continue
-		}
-
-		if image == nil {
-			log.Errorf("ERROR: Image for container %s does not exist!", container.ID[0:12])
-			continue
-
-		}
-
-		version := env["GALAXY_VERSION"]
-
-		imageDiffers := image.ID != appCfg.VersionID() && appCfg.VersionID() != ""
-		versionDiffers := version != strconv.FormatInt(appCfg.ID(), 10) && version != ""
+	if limit > 0 && len(containers) > limit {
+		containers = containers[:limit]
+	}
 
-		if imageDiffers || versionDiffers {
-			s.stopContainer(container)
-		}
+	for _, container := range containers {
+		s.stopContainer(container)
 	}
-	return nil
+	return containers, nil
+}
+
+func (s *ServiceRuntime) StopAllButCurrentVersion(appCfg config.App) ([]*docker.Container, error) {
+	return s.StopOldVersion(appCfg, 0)
 }
 
 // TODO: these aren't called from anywhere. Are they useful?
@@ -374,49 +581,129 @@ func (s *ServiceRuntime) StopAllButLatest(env string, stopCutoff int64) error {
 }
 */
 
-// Stop any running galaxy containers that are not assigned to us
 // TODO: We call ManagedContainers a lot, repeatedly listing and inspecting all containers.
-func (s *ServiceRuntime) StopUnassigned(env, pool string) error {
+
+// StopUnassigned stops any running galaxy containers of apps that aren't
+// assigned to env/pool, and returns them. Blacklisted containers are skipped.
+func (s *ServiceRuntime) StopUnassigned(env, pool string) ([]*docker.Container, error) {
 	containers, err := s.ManagedContainers()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, container := range containers {
+	stopped := []*docker.Container{}
+	for _, container := range notBlacklisted(containers) {
 		name := s.EnvFor(container)["GALAXY_APP"]
 
-		pools, err := s.configStore.ListAssignedPools(env, name)
+		assigned, err := s.assignedTo(env, pool, name)
 		if err != nil {
 			log.Errorf("ERROR: Unable to list pool assignments for %s: %s", container.Name, err)
 			continue
 		}
 
-		if len(pools) == 0 || !utils.StringInSlice(pool, pools) {
+		if !assigned {
 			log.Warnf("galaxy container %s not assigned to %s/%s", container.Name, env, pool)
 			s.stopContainer(container)
+			stopped = append(stopped, container)
 		}
 	}
-	return nil
+	return stopped, nil
 }
 
-func (s *ServiceRuntime) StopAll(env string) error {
+func (s *ServiceRuntime) assignedTo(env, pool, app string) (bool, error) {
+	pools, err := s.configStore.ListAssignedPools(env, app)
+	if err != nil {
+		return false, err
+	}
+	return utils.StringInSlice(pool, pools), nil
+}
+
+// RunningApp is a galaxy container running on this host
+type RunningApp struct {
+	Name        string
+	Version     string
+	Instance    int
+	ImageID     string
+	ContainerID string
+}
 
+// RunningApps returns the containers running on this host for apps assigned
+// to env/pool, sorted by app and instance. Containers of unassigned apps are
+// left out, as StopUnassigned would stop them.
+func (s *ServiceRuntime) RunningApps(env, pool string) ([]RunningApp, error) {
 	containers, err := s.ManagedContainers()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	assigned := map[string]bool{}
+	apps := []RunningApp{}
+	for _, container := range containers {
+		cenv := s.EnvFor(container)
+		name := cenv["GALAXY_APP"]
+
+		if _, ok := assigned[name]; !ok {
+			assigned[name], err = s.assignedTo(env, pool, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !assigned[name] {
+			continue
+		}
+
+		instance, err := strconv.Atoi(cenv["GALAXY_INSTANCE"])
+		if err != nil {
+			log.Warnf("WARN: Invalid instance %q for %s. Ignoring.", cenv["GALAXY_INSTANCE"], container.ID[:12])
+			continue
+		}
+
+		apps = append(apps, RunningApp{
+			Name:        name,
+			Version:     container.Config.Image,
+			Instance:    instance,
+			ImageID:     container.Image,
+			ContainerID: container.ID,
+		})
+	}
+
+	sort.Sort(runningApps(apps))
+	return apps, nil
+}
+
+type runningApps []RunningApp
+
+func (r runningApps) Len() int      { return len(r) }
+func (r runningApps) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r runningApps) Less(i, j int) bool {
+	if r[i].Name != r[j].Name {
+		return r[i].Name < r[j].Name
+	}
+	return r[i].Instance < r[j].Instance
+}
+
+// StopAll stops all galaxy managed containers that aren't blacklisted, and
+// returns them.
+func (s *ServiceRuntime) StopAll(env string) ([]*docker.Container, error) {
+
+	containers, err := s.ManagedContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	containers = notBlacklisted(containers)
 	for _, c := range containers {
 		s.stopContainer(c)
 	}
 
-	return nil
+	return containers, nil
 }
 
 func (s *ServiceRuntime) GetImageByName(img string) (*docker.APIImages, error) {
 	imgs, err := s.dockerClient.ListImages(docker.ListImagesOptions{All: true})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	for _, image := range imgs {
@@ -428,31 +715,47 @@ func (s *ServiceRuntime) GetImageByName(img string) (*docker.APIImages, error) {
 
 }
 
-func (s *ServiceRuntime) RunCommand(env string, appCfg config.App, cmd []string) (*docker.Container, error) {
-
-	// see if we have the image locally
-	fmt.Fprintf(os.Stderr, "Pulling latest image for %s\n", appCfg.Version())
+// createCommandContainer creates a container running cmd with the app's env.
+// The container's instance slot stays reserved until release is called, once
+// the container has started.
+func (s *ServiceRuntime) createCommandContainer(env string, appCfg config.App, cmd []string) (*docker.Container, func(), error) {
 	_, err := s.PullImage(appCfg.Version(), appCfg.VersionID())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	instanceId, err := s.NextInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
+	instanceId, release, err := s.reserveInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	created := false
+	defer func() {
+		if !created {
+			release()
+		}
+	}()
+
+	publicDns := lookupPublicHostname()
+	vars := s.substitutions(env, appCfg, instanceId, publicDns)
+
 	envVars := []string{"ENV=" + env}
 
 	for key, value := range appCfg.Env() {
 		if key == "ENV" {
 			continue
 		}
-		envVars = append(envVars, strings.ToUpper(key)+"="+s.replaceVarEnv(value, s.hostIP))
+
+		key, err := envKey(appCfg, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		envVars = append(envVars, key+"="+replaceVarEnv(value, vars))
 	}
 	envVars = append(envVars, "GALAXY_APP="+appCfg.Name())
 	envVars = append(envVars, "GALAXY_VERSION="+strconv.FormatInt(appCfg.ID(), 10))
 	envVars = append(envVars, fmt.Sprintf("GALAXY_INSTANCE=%s", strconv.FormatInt(int64(instanceId), 10)))
+	envVars = append(envVars, fmt.Sprintf("PUBLIC_HOSTNAME=%s", publicDns))
 
 	runCmd := []string{"/bin/sh", "-c", strings.Join(cmd, " ")}
 
@@ -466,15 +769,48 @@ func (s *ServiceRuntime) RunCommand(env string, appCfg config.App, cmd []string)
 			OpenStdin:    false,
 		},
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	created = true
+	return container, release, nil
+}
+
+func (s *ServiceRuntime) commandHostConfig() *docker.HostConfig {
+	config := &docker.HostConfig{}
+	if s.dns != "" {
+		config.DNS = []string{s.dns}
+	}
+	return config
+}
 
+func (s *ServiceRuntime) RunCommand(env string, appCfg config.App, cmd []string) (*docker.Container, error) {
+
+	// see if we have the image locally
+	fmt.Fprintf(os.Stderr, "Pulling latest image for %s\n", appCfg.Version())
+	container, release, err := s.createCommandContainer(env, appCfg, cmd)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
+	// only handle signals for as long as the command runs
 	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
 	signal.Notify(c, os.Interrupt, os.Kill)
+	defer func() {
+		signal.Stop(c)
+		close(done)
+	}()
+
 	go func(s *ServiceRuntime, containerId string) {
-		<-c
+		select {
+		case <-c:
+		case <-done:
+			return
+		}
+
 		log.Println("Stopping container...")
 		err := s.dockerClient.StopContainer(containerId, 3)
 		if err != nil {
@@ -492,11 +828,7 @@ func (s *ServiceRuntime) RunCommand(env string, appCfg config.App, cmd []string)
 	defer s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
 		ID: container.ID,
 	})
-	config := &docker.HostConfig{}
-	if s.dns != "" {
-		config.DNS = []string{s.dns}
-	}
-	err = s.dockerClient.StartContainer(container.ID, config)
+	err = s.dockerClient.StartContainer(container.ID, s.commandHostConfig())
 
 	if err != nil {
 		return container, err
@@ -521,6 +853,52 @@ func (s *ServiceRuntime) RunCommand(env string, appCfg config.App, cmd []string)
 	return container, err
 }
 
+// RunCommandCapture runs cmd like RunCommand, but returns its output and exit
+// code instead of writing to the terminal. The container is removed once the
+// command exits.
+func (s *ServiceRuntime) RunCommandCapture(env string, appCfg config.App, cmd []string) ([]byte, []byte, int, error) {
+	container, release, err := s.createCommandContainer(env, appCfg, cmd)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer release()
+
+	return s.runCaptured(container, s.commandHostConfig())
+}
+
+// runCaptured starts a created one-off container and waits for it to exit,
+// returning its output and exit code. The container is removed afterwards.
+func (s *ServiceRuntime) runCaptured(container *docker.Container, hostConfig *docker.HostConfig) ([]byte, []byte, int, error) {
+	defer s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+		ID: container.ID,
+	})
+
+	err := s.dockerClient.StartContainer(container.ID, hostConfig)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = s.dockerClient.AttachToContainer(docker.AttachToContainerOptions{
+		Container:    container.ID,
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+		Logs:         true,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	exitCode, err := s.dockerClient.WaitContainer(container.ID)
+	if err != nil {
+		return stdout.Bytes(), stderr.Bytes(), 0, err
+	}
+	return stdout.Bytes(), stderr.Bytes(), exitCode, nil
+}
+
 func (s *ServiceRuntime) StartInteractive(env, pool string, appCfg config.App) error {
 
 	// see if we have the image locally
@@ -530,24 +908,46 @@ func (s *ServiceRuntime) StartInteractive(env, pool string, appCfg config.App) e
 		return err
 	}
 
+	instanceId, release, err := s.reserveInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	publicDns := lookupPublicHostname()
+	vars := s.substitutions(env, appCfg, instanceId, publicDns)
+
 	args := []string{
 		"run", "--rm", "-i",
 	}
 	args = append(args, "-e")
 	args = append(args, "ENV"+"="+env)
 
+	// docker applies the -e options below on top of the file
+	if envFile := appCfg.GetEnvFile(pool); envFile != "" {
+		if _, err := os.Stat(envFile); err != nil {
+			return fmt.Errorf("unable to read env file for %s: %s", appCfg.Name(), err)
+		}
+		args = append(args, "--env-file", envFile)
+	}
+
 	for key, value := range appCfg.Env() {
 		if key == "ENV" {
 			continue
 		}
 
+		key, err := envKey(appCfg, key)
+		if err != nil {
+			return err
+		}
+
 		args = append(args, "-e")
-		args = append(args, strings.ToUpper(key)+"="+s.replaceVarEnv(value, s.hostIP))
+		args = append(args, key+"="+replaceVarEnv(value, vars))
 	}
 
 	args = append(args, "-e")
 	args = append(args, fmt.Sprintf("HOST_IP=%s", s.hostIP))
-	if s.dns != "" {
+	if s.dns != "" && networkAllowsDNS(appCfg.GetNetwork(pool)) {
 		args = append(args, "--dns")
 		args = append(args, s.dns)
 	}
@@ -555,27 +955,34 @@ func (s *ServiceRuntime) StartInteractive(env, pool string, appCfg config.App) e
 	args = append(args, fmt.Sprintf("GALAXY_APP=%s", appCfg.Name()))
 	args = append(args, "-e")
 	args = append(args, fmt.Sprintf("GALAXY_VERSION=%s", strconv.FormatInt(appCfg.ID(), 10)))
+	args = append(args, "-e")
+	args = append(args, fmt.Sprintf("GALAXY_INSTANCE=%s", strconv.FormatInt(int64(instanceId), 10)))
+	args = append(args, "-e")
+	args = append(args, fmt.Sprintf("PUBLIC_HOSTNAME=%s", publicDns))
 
-	instanceId, err := s.NextInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
+	mem, swap, err := memoryLimits(appCfg, pool)
 	if err != nil {
 		return err
 	}
-	args = append(args, "-e")
-	args = append(args, fmt.Sprintf("GALAXY_INSTANCE=%s", strconv.FormatInt(int64(instanceId), 10)))
 
-	publicDns, err := EC2PublicHostname()
-	if err != nil {
-		log.Warnf("Unable to determine public hostname. Not on AWS? %s", err)
-		publicDns = "127.0.0.1"
+	if mem != 0 {
+		args = append(args, "-m")
+		args = append(args, utils.FormatMemory(mem))
 	}
 
-	args = append(args, "-e")
-	args = append(args, fmt.Sprintf("PUBLIC_HOSTNAME=%s", publicDns))
+	if swap != 0 {
+		args = append(args, "--memory-swap")
+		args = append(args, utils.FormatMemory(swap))
+	}
 
-	mem := appCfg.GetMemory(pool)
-	if mem != "" {
-		args = append(args, "-m")
-		args = append(args, mem)
+	network, err := s.networkMode(appCfg, pool)
+	if err != nil {
+		return err
+	}
+
+	if network != "" {
+		args = append(args, "--network")
+		args = append(args, network)
 	}
 
 	cpu := appCfg.GetCPUShares(pool)
@@ -584,7 +991,23 @@ func (s *ServiceRuntime) StartInteractive(env, pool string, appCfg config.App) e
 		args = append(args, cpu)
 	}
 
-	args = append(args, []string{"-t", appCfg.Version(), "/bin/sh"}...)
+	if appCfg.GetReadOnlyRootfs(pool) {
+		args = append(args, "--read-only")
+	}
+
+	for _, mount := range tmpfsArgs(appCfg.GetTmpfs(pool)) {
+		args = append(args, "--tmpfs", mount)
+	}
+
+	// always start a shell, but still run it through a configured entrypoint
+	shell := []string{"/bin/sh"}
+	if entrypoint := appCfg.GetEntrypoint(pool); len(entrypoint) > 0 {
+		args = append(args, "--entrypoint", entrypoint[0])
+		shell = append(append([]string{}, entrypoint[1:]...), shell...)
+	}
+
+	args = append(args, "-t", appCfg.Version())
+	args = append(args, shell...)
 	// shell out to docker run to get signal forwarded and terminal setup correctly
 	//cmd := exec.Command("docker", "run", "-rm", "-i", "-t", appCfg.Version(), "/bin/bash")
 	cmd := exec.Command("docker", args...)
@@ -619,18 +1042,30 @@ func (s *ServiceRuntime) Start(env, pool string, appCfg config.App) (*docker.Con
 		return nil, err
 	}
 
-	// setup env vars from etcd
-	var envVars []string
-	envVars = append(envVars, "ENV"+"="+env)
+	network, err := s.networkMode(appCfg, pool)
+	if err != nil {
+		return nil, err
+	}
 
-	for key, value := range appCfg.Env() {
-		if key == "ENV" {
-			continue
-		}
-		envVars = append(envVars, strings.ToUpper(key)+"="+s.replaceVarEnv(value, s.hostIP))
+	mem, swap, err := memoryLimits(appCfg, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := s.staticIP(appCfg, pool, network)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceId, release, err := s.reserveInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
+	if err != nil {
+		return nil, err
 	}
+	defer release()
+
+	publicDns := lookupPublicHostname()
 
-	instanceId, err := s.NextInstanceSlot(appCfg.Name(), strconv.FormatInt(appCfg.ID(), 10))
+	envVars, err := s.appEnv(env, pool, appCfg, s.substitutions(env, appCfg, instanceId, publicDns))
 	if err != nil {
 		return nil, err
 	}
@@ -640,26 +1075,39 @@ func (s *ServiceRuntime) Start(env, pool string, appCfg config.App) (*docker.Con
 	envVars = append(envVars, fmt.Sprintf("GALAXY_VERSION=%s", strconv.FormatInt(appCfg.ID(), 10)))
 	envVars = append(envVars, fmt.Sprintf("GALAXY_INSTANCE=%s", strconv.FormatInt(int64(instanceId), 10)))
 
-	publicDns, err := EC2PublicHostname()
-	if err != nil {
-		log.Warnf("Unable to determine public hostname. Not on AWS? %s", err)
-		publicDns = "127.0.0.1"
+	// recorded so the container can be stopped with the grace period it was
+	// started with
+	if timeout := appCfg.GetStopTimeout(pool); timeout > 0 {
+		envVars = append(envVars, fmt.Sprintf("GALAXY_STOP_TIMEOUT=%d", timeout))
 	}
+
 	envVars = append(envVars, fmt.Sprintf("PUBLIC_HOSTNAME=%s", publicDns))
 
-	containerName := appCfg.ContainerName() + "." + strconv.FormatInt(int64(instanceId), 10)
+	containerName, err := s.ContainerName(env, pool, appCfg, instanceId)
+	if err != nil {
+		return nil, err
+	}
+
 	container, err := s.dockerClient.InspectContainer(containerName)
 	_, ok := err.(*docker.NoSuchContainer)
 	if err != nil && !ok {
 		return nil, err
 	}
 
+	// The hook only runs when a new container is about to be created, and
+	// before the old one is stopped, so a failure leaves it alone.
+	if container == nil || container.Image != image.ID {
+		if err := s.runPreStart(appCfg, pool, img, envVars, network); err != nil {
+			return nil, err
+		}
+	}
+
 	// Existing container is running or stopped.  If the image has changed, stop
 	// and re-create it.
 	if container != nil && container.Image != image.ID {
 		if container.State.Running || container.State.Restarting || container.State.Paused {
 			log.Printf("Stopping %s version %s running as %s", appCfg.Name(), appCfg.Version(), container.ID[0:12])
-			err := s.dockerClient.StopContainer(container.ID, 10)
+			err := s.dockerClient.StopContainer(container.ID, s.stopTimeout(container))
 			if err != nil {
 				return nil, err
 			}
@@ -676,20 +1124,18 @@ func (s *ServiceRuntime) Start(env, pool string, appCfg config.App) (*docker.Con
 	}
 
 	if container == nil {
+		labels := config.MetaLabels(appCfg.GetServiceMeta(pool))
 
+		// nil Cmd and Entrypoint leave the image defaults in place
 		config := &docker.Config{
-			Image: img,
-			Env:   envVars,
+			Image:      img,
+			Env:        envVars,
+			Cmd:        appCfg.GetCommand(pool),
+			Entrypoint: appCfg.GetEntrypoint(pool),
+			Labels:     labels,
 		}
 
-		mem := appCfg.GetMemory(pool)
-		if mem != "" {
-			m, err := utils.ParseMemory(mem)
-			if err != nil {
-				return nil, err
-			}
-			config.Memory = m
-		}
+		config.Memory = mem
 
 		cpu := appCfg.GetCPUShares(pool)
 		if cpu != "" {
@@ -706,6 +1152,22 @@ func (s *ServiceRuntime) Start(env, pool string, appCfg config.App) (*docker.Con
 		if err != nil {
 			return nil, err
 		}
+
+		if ip != "" {
+			log.Printf("Connecting %s to %s as %s", container.ID[0:12], network, ip)
+			err = s.dockerClient.ConnectNetwork(network, docker.NetworkConnectionOptions{
+				Container: container.ID,
+				EndpointConfig: &docker.EndpointConfig{
+					IPAMConfig: &docker.EndpointIPAMConfig{IPv4Address: ip},
+				},
+			})
+			if err != nil {
+				// don't leave a container without its IP to be reused by the
+				// next Start
+				s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID})
+				return nil, fmt.Errorf("unable to connect %s to %s as %s: %s", appCfg.Name(), network, ip, err)
+			}
+		}
 	}
 
 	log.Printf("Starting %s version %s running as %s", appCfg.Name(), appCfg.Version(), container.ID[0:12])
@@ -720,71 +1182,247 @@ func (s *ServiceRuntime) Start(env, pool string, appCfg config.App) (*docker.Con
 			Type:   "syslog",
 			Config: map[string]string{"syslog-tag": containerName},
 		},
+		NetworkMode:    network,
+		Memory:         mem,
+		MemorySwap:     swap,
+		ReadonlyRootfs: appCfg.GetReadOnlyRootfs(pool),
 	}
 
-	if s.dns != "" {
+	if tmpfs := appCfg.GetTmpfs(pool); len(tmpfs) > 0 {
+		config.Tmpfs = tmpfs
+	}
+
+	if s.dns != "" && networkAllowsDNS(network) {
 		config.DNS = []string{s.dns}
 	}
 	err = s.dockerClient.StartContainer(container.ID, config)
+	if err != nil {
+		s.metrics().IncrCounter("container.start.failure")
+		return container, err
+	}
 
-	return container, err
+	s.metrics().IncrCounter("container.start")
+	return container, nil
 }
 
-// TODO: not called, is this needed?
-/*
-func (s *ServiceRuntime) StartIfNotRunning(env, pool string, appCfg config.App) (bool, *docker.Container, error) {
+// memoryLimits returns the memory and memory + swap limits in bytes for an app
+// in pool. Either is 0 if it's not set.
+func memoryLimits(appCfg config.App, pool string) (int64, int64, error) {
+	mem, err := utils.ParseMemory(appCfg.GetMemory(pool))
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad memory limit for %s: %s", appCfg.Name(), err)
+	}
 
-	containers, err := s.ManagedContainers()
+	swap, err := utils.ParseMemory(appCfg.GetMemorySwap(pool))
 	if err != nil {
-		return false, nil, err
+		return 0, 0, fmt.Errorf("bad memory swap limit for %s: %s", appCfg.Name(), err)
 	}
 
-	image, err := s.InspectImage(appCfg.Version())
+	// docker only takes a swap limit alongside a memory limit it covers
+	if swap != 0 && (mem == 0 || swap < mem) {
+		return 0, 0, fmt.Errorf("memory swap limit for %s must be at least its memory limit", appCfg.Name())
+	}
+	return mem, swap, nil
+}
+
+// appEnv returns the env for a container of appCfg in pool. It's made up of
+// the app's env file, if it has one, and the app's config env, with the config
+// taking precedence. The env file is read on every call, so its values never
+// end up in the config store. References to the subst variables in the
+// values are replaced.
+func (s *ServiceRuntime) appEnv(env, pool string, appCfg config.App, subst map[string]string) ([]string, error) {
+	vars := make(map[string]string)
+
+	if envFile := appCfg.GetEnvFile(pool); envFile != "" {
+		fileEnv, err := utils.ParseEnvFile(envFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read env file for %s: %s", appCfg.Name(), err)
+		}
+
+		for key, value := range fileEnv {
+			key, err := envKey(appCfg, key)
+			if err != nil {
+				return nil, err
+			}
+			vars[key] = value
+		}
+	}
+
+	for key, value := range appCfg.Env() {
+		key, err := envKey(appCfg, key)
+		if err != nil {
+			return nil, err
+		}
+		vars[key] = value
+	}
+
+	envVars := []string{"ENV" + "=" + env}
+	for key, value := range vars {
+		if key == "ENV" {
+			continue
+		}
+		envVars = append(envVars, key+"="+replaceVarEnv(value, subst))
+	}
+	return envVars, nil
+}
+
+var validEnvKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envKey returns key upper-cased for a container env. Keys that aren't valid
+// env var names once upper-cased are an error, rather than an assignment the
+// container's shell may reject.
+func envKey(appCfg config.App, key string) (string, error) {
+	key = strings.ToUpper(key)
+	if !validEnvKey.MatchString(key) {
+		return "", fmt.Errorf("invalid env var %q for %s", key, appCfg.Name())
+	}
+	return key, nil
+}
+
+// staticIP returns the validated static IP for an app in pool on network, or
+// an empty string if it doesn't have one. The IP must be an IPv4 address on a
+// user-defined network, and inside the network's subnets if docker says what
+// they are.
+func (s *ServiceRuntime) staticIP(appCfg config.App, pool, network string) (string, error) {
+	ip := appCfg.GetStaticIP(pool)
+	if ip == "" {
+		return "", nil
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return "", fmt.Errorf("invalid static IP %q for %s, want an IPv4 address", ip, appCfg.Name())
+	}
+
+	switch {
+	case network == "", network == "host", network == "none", network == "bridge", strings.HasPrefix(network, "container:"):
+		return "", fmt.Errorf("static IP %s for %s needs a user-defined network, not %q", ip, appCfg.Name(), network)
+	}
+
+	info, err := s.dockerClient.NetworkInfo(network)
 	if err != nil {
-		return false, nil, err
+		return "", fmt.Errorf("unable to inspect network %s: %s", network, err)
 	}
 
-	var running *docker.Container
-	for _, container := range containers {
-		cenv := s.EnvFor(container)
-		if cenv["GALAXY_APP"] == appCfg.Name() &&
-			cenv["GALAXY_VERSION"] == strconv.FormatInt(appCfg.ID(), 10) &&
-			image.ID == container.Image {
-			running = container
-			break
+	subnets := []string{}
+	for _, ipam := range info.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(ipam.Subnet)
+		if err != nil {
+			continue
+		}
+
+		if subnet.Contains(addr) {
+			return ip, nil
 		}
+		subnets = append(subnets, subnet.String())
 	}
 
-	if running != nil {
-		return false, running, nil
+	if len(subnets) > 0 {
+		return "", fmt.Errorf("static IP %s for %s isn't in network %s (%s)", ip, appCfg.Name(), network, strings.Join(subnets, ", "))
 	}
+	return ip, nil
+}
 
-	err = s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-		ID: appCfg.ContainerName(),
-	})
-	_, ok := err.(*docker.NoSuchContainer)
-	if err != nil && !ok {
+// networkMode returns the docker NetworkMode for an app in pool. Other than
+// the host, none and bridge modes, the network must be an existing
+// user-defined network. An empty mode uses docker's default.
+func (s *ServiceRuntime) networkMode(appCfg config.App, pool string) (string, error) {
+	network := appCfg.GetNetwork(pool)
+	switch {
+	case network == "", network == "host", network == "none", network == "bridge":
+		return network, nil
+	case strings.HasPrefix(network, "container:"):
+		return network, nil
+	}
+
+	_, err := s.dockerClient.NetworkInfo(network)
+	if _, ok := err.(*docker.NoSuchNetwork); ok {
+		return "", fmt.Errorf("network %s for %s does not exist. Create it with `docker network create %s`",
+			network, appCfg.Name(), network)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect network %s: %s", network, err)
+	}
+	return network, nil
+}
+
+// docker refuses custom DNS servers for containers sharing another network
+// stack
+func networkAllowsDNS(network string) bool {
+	return network != "host" && !strings.HasPrefix(network, "container:")
+}
+
+// StartIfNotRunning starts appCfg unless a container for its current config
+// version is already running from the image its version refers to now. The
+// image is looked up by tag, so a tag that was moved to a new image starts a
+// new container. It returns whether a container was started, and the running
+// container either way.
+func (s *ServiceRuntime) StartIfNotRunning(env, pool string, appCfg config.App) (bool, *docker.Container, error) {
+
+	containers, err := s.ManagedContainers()
+	if err != nil {
+		return false, nil, err
+	}
+
+	// an image that isn't here yet can't be running, Start will pull it
+	image, err := s.InspectImage(appCfg.Version())
+	if err != nil && err != docker.ErrNoSuchImage {
 		return false, nil, err
 	}
 
+	if image != nil {
+		for _, container := range containers {
+			cenv := s.EnvFor(container)
+			if cenv["GALAXY_APP"] == appCfg.Name() &&
+				cenv["GALAXY_VERSION"] == strconv.FormatInt(appCfg.ID(), 10) &&
+				image.ID == container.Image {
+				return false, container, nil
+			}
+		}
+	}
+
 	container, err := s.Start(env, pool, appCfg)
-	return true, container, err
+	if err != nil {
+		return false, nil, err
+	}
+	return true, container, nil
 }
-*/
 
 // Find a best match for docker authentication
 // Docker's config is a bunch of special-cases, try to cover most of them here.
+// A credential helper configured for the registry in the docker CLI's
+// config.json is asked first, falling back to the static auths if it has
+// nothing stored. A configured helper that's missing or fails is an error.
 // TODO: This may not work at all when we switch to a private V2 registry
-func findAuth(registry string) docker.AuthConfiguration {
+func (s *ServiceRuntime) findAuth(registry string) (docker.AuthConfiguration, error) {
+	// index images are stored under the index server
+	server := registry
+	if !isRegistryHost(registry) {
+		server = s.indexServer()
+	}
+
+	host := server
+	if u, e := url.Parse(server); e == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	if helper := credHelper(dockerConfigPath(os.Getenv), host); helper != "" {
+		auth, found, err := helperAuth(helper, server)
+		if err != nil || found {
+			return auth, err
+		}
+	}
+
 	// Ignore the error. If .dockercfg doesn't exist, maybe we don't need auth
-	auths, _ := docker.NewAuthConfigurationsFromDockerCfg()
+	auths, _ := dockerAuths()
 	if auths == nil || auths.Configs == nil {
-		return docker.AuthConfiguration{}
+		return docker.AuthConfiguration{}, nil
 	}
 
 	auth, ok := auths.Configs[registry]
 	if ok {
-		return auth
+		return auth, nil
 	}
 	// no exact match, so let's try harder
 
@@ -795,66 +1433,180 @@ func findAuth(registry string) docker.AuthConfiguration {
 			reg = u.Host
 		}
 		if registry == reg {
-			return auth
+			return auth, nil
 		}
 	}
 
 	// Still no match
 	// Try the default docker index server
-	return auths.Configs[defaultIndexServer]
+	return auths.Configs[s.indexServer()], nil
+}
+
+func (s *ServiceRuntime) indexServer() string {
+	if s.IndexServer != "" {
+		return s.IndexServer
+	}
+	return defaultIndexServer
+}
+
+// pullSource returns the registry and repository to pull from. Images that
+// don't name a registry host come from the mirror if one is set. Official
+// images live under library/ on the mirror, as they do on the index.
+func (s *ServiceRuntime) pullSource(registry, repository string) (string, string) {
+	if s.registryMirror == "" || isRegistryHost(registry) {
+		return registry, repository
+	}
+
+	// SplitDockerImage returns the user of an index image, like litl/web, as
+	// the registry
+	repository = repositoryWithRegistry(registry, repository)
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return s.registryMirror, repository
 }
 
+// isRegistryHost follows docker in only treating the first part of an image
+// name as a registry if it looks like a host.
+func isRegistryHost(name string) bool {
+	return strings.ContainsAny(name, ".:") || name == "localhost"
+}
+
+// PullImage makes sure the image for version is available locally, pulling it
+// if it's missing or doesn't match the image id. If version is pinned to a
+// digest, e.g. myapp@sha256:..., the image is pulled and verified by that
+// digest instead. The id is an image ID, like sha256:..., not a manifest
+// digest, so a tagged version is always pulled by tag. If the tag still isn't
+// id after pulling, an error is returned rather than the old image.
+//
+// Tagged images pulled through the registry mirror are tagged locally under
+// version as well, so they can be run by their usual name.
 func (s *ServiceRuntime) PullImage(version, id string) (*docker.Image, error) {
-	image, err := s.InspectImage(version)
+	return s.PullImageProgress(version, id, nil)
+}
+
+// PullImageProgress is PullImage, calling onProgress with each message docker
+// sends while pulling instead of logging them. Progress is logged as usual if
+// onProgress is nil.
+func (s *ServiceRuntime) PullImageProgress(version, id string, onProgress func(JSONMessage)) (*docker.Image, error) {
+	registry, repository, tag, digest := utils.SplitDockerImage(version)
+
+	// the digest pins the image, so there's no id to compare
+	if digest != "" {
+		return s.pullImage(version, registry, repository, digest, "", onProgress)
+	}
+
+	return s.pullImage(version, registry, repository, tag, id, onProgress)
+}
+
+func repositoryWithRegistry(registry, repository string) string {
+	if registry != "" {
+		return registry + "/" + repository
+	}
+	return repository
+}
+
+// pull image by tag or digest. An image found locally is only re-pulled if id
+// is set and doesn't match.
+func (s *ServiceRuntime) pullImage(image, registry, repository, tag, id string, onProgress func(JSONMessage)) (*docker.Image, error) {
+	img, err := s.InspectImage(image)
 
 	if err != nil && err != docker.ErrNoSuchImage {
 		return nil, err
 	}
 
-	if image != nil && image.ID == id {
-		return image, nil
+	if img != nil && (id == "" || img.ID == id) {
+		return img, nil
 	}
 
-	registry, repository, tag := utils.SplitDockerImage(version)
+	// Don't trust a stale tag. Pull it again and check it moved.
+	if img != nil && id != image {
+		log.Printf("Image %s is %s locally, pulling %s", image, img.ID, id)
+	}
 
 	// No, pull it down locally
+	pullRegistry, pullRepository := s.pullSource(registry, repository)
 	pullOpts := docker.PullImageOptions{
-		Repository:   repository,
-		Tag:          tag,
-		OutputStream: log.DefaultLogger}
-
-	dockerAuth := findAuth(registry)
+		Repository: repositoryWithRegistry(pullRegistry, pullRepository),
+		Registry:   pullRegistry,
+		Tag:        tag,
+	}
 
-	if registry != "" {
-		pullOpts.Repository = registry + "/" + repository
-	} else {
-		pullOpts.Repository = repository
+	dockerAuth, err := s.findAuth(pullRegistry)
+	if err != nil {
+		s.metrics().IncrCounter("image.pull.failure")
+		return nil, err
 	}
-	pullOpts.Registry = registry
-	pullOpts.Tag = tag
 
+	start := time.Now()
 	retries := 0
 	for {
 		retries += 1
-		err = s.dockerClient.PullImage(pullOpts, dockerAuth)
+		err = s.pull(pullOpts, dockerAuth, onProgress)
 		if err != nil {
 
 			// Don't retry 404, they'll never succeed
-			if err.Error() == "HTTP code: 404" {
-				return image, nil
+			if isNotFound(err) {
+				s.metrics().IncrCounter("image.pull.failure")
+				if img == nil {
+					return nil, ErrImageNotFound
+				}
+				return checkImageID(image, img, id)
 			}
 
 			if retries > 3 {
-				return image, err
+				s.metrics().IncrCounter("image.pull.failure")
+				return img, &PullError{Version: image, Attempts: retries, Cause: err}
 			}
-			log.Errorf("ERROR: error pulling image %s. Attempt %d: %s", version, retries, err)
+			s.metrics().IncrCounter("image.pull.retry")
+			log.Errorf("ERROR: error pulling image %s. Attempt %d: %s", image, retries, err)
 			continue
 		}
 		break
 	}
+	s.metrics().IncrCounter("image.pull.success")
+	s.metrics().ObserveDuration("image.pull", time.Since(start))
+
+	if pullRegistry != registry {
+		img, err = s.tagMirrored(pullOpts.Repository, repositoryWithRegistry(registry, repository), tag)
+	} else {
+		img, err = s.InspectImage(image)
+	}
 
-	return s.InspectImage(version)
+	if err != nil {
+		return nil, err
+	}
+	return checkImageID(image, img, id)
+}
 
+// checkImageID fails if img isn't the image id, like when the tag still
+// points to an old build after a pull. Images pulled by digest are passed no
+// id, so they're never checked. Neither is an id that's the image name
+// itself, which Start passes to always pull an app without a VersionID.
+func checkImageID(image string, img *docker.Image, id string) (*docker.Image, error) {
+	if img != nil && id != "" && id != image && img.ID != id {
+		return nil, &ImageMismatchError{Version: image, ID: img.ID, Want: id}
+	}
+	return img, nil
+}
+
+// tagMirrored tags an image pulled from the mirror with the name it was
+// requested by. A digest can't be a tag, so those are only found under the
+// mirror's name.
+func (s *ServiceRuntime) tagMirrored(mirrored, repository, tag string) (*docker.Image, error) {
+	if utils.IsDigest(tag) {
+		return s.InspectImage(mirrored + "@" + tag)
+	}
+
+	err := s.dockerClient.TagImage(mirrored+":"+tag, docker.TagImageOptions{
+		Repo:  repository,
+		Tag:   tag,
+		Force: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.InspectImage(repository + ":" + tag)
 }
 
 func (s *ServiceRuntime) RegisterAll(env, pool, hostIP string) ([]*config.ServiceRegistration, error) {
@@ -863,6 +1615,19 @@ func (s *ServiceRuntime) RegisterAll(env, pool, hostIP string) ([]*config.Servic
 	//        effect of stopping containers
 	s.StopUnassigned(env, pool)
 
+	return s.register(env, pool, hostIP, "")
+}
+
+// RegisterApp registers only app's containers on this host. Unlike
+// RegisterAll it doesn't stop unassigned containers, so it's safe to call
+// after a single app has been deployed.
+func (s *ServiceRuntime) RegisterApp(env, pool, hostIP, app string) ([]*config.ServiceRegistration, error) {
+	return s.register(env, pool, hostIP, app)
+}
+
+// register registers the managed containers of app, or of every app if app is
+// empty. A container that fails to register is logged and skipped.
+func (s *ServiceRuntime) register(env, pool, hostIP, app string) ([]*config.ServiceRegistration, error) {
 	containers, err := s.ManagedContainers()
 	if err != nil {
 		return nil, err
@@ -872,6 +1637,9 @@ func (s *ServiceRuntime) RegisterAll(env, pool, hostIP string) ([]*config.Servic
 
 	for _, container := range containers {
 		name := s.EnvFor(container)["GALAXY_APP"]
+		if app != "" && name != app {
+			continue
+		}
 
 		registration, err := s.configStore.RegisterService(env, pool, hostIP, container)
 		if err != nil {
@@ -882,7 +1650,6 @@ func (s *ServiceRuntime) RegisterAll(env, pool, hostIP string) ([]*config.Servic
 	}
 
 	return registrations, nil
-
 }
 
 func (s *ServiceRuntime) UnRegisterAll(env, pool, hostIP string) ([]*docker.Container, error) {
@@ -909,14 +1676,53 @@ func (s *ServiceRuntime) UnRegisterAll(env, pool, hostIP string) ([]*docker.Cont
 	return removed, nil
 }
 
+// logExit logs why an app's container exited: cleanly, with an error or by
+// being OOM killed.
+func logExit(name string, container *docker.Container) {
+	switch {
+	case container.State.OOMKilled:
+		log.Warnf("%s container %s was OOM killed", name, container.ID[:12])
+	case container.State.ExitCode != 0:
+		log.Warnf("%s container %s crashed with exit code %d", name, container.ID[:12], container.State.ExitCode)
+	default:
+		log.Printf("%s container %s exited cleanly", name, container.ID[:12])
+	}
+}
+
 // RegisterEvents monitors the docker daemon for events, and returns those
-// that require registration action over the listener chan.
-func (s *ServiceRuntime) RegisterEvents(env, pool, hostIP string, listener chan ContainerEvent) error {
+// that require registration action over the listener chan. It keeps watching
+// until ctx is cancelled, then removes its docker event listener.
+func (s *ServiceRuntime) RegisterEvents(ctx context.Context, env, pool, hostIP string, listener chan ContainerEvent) error {
 	go func() {
 		c := make(chan *docker.APIEvents)
-
 		watching := false
+
+		// stop watching when the caller is done, so the docker listener
+		// isn't left behind
+		defer func() {
+			if watching {
+				s.dockerClient.RemoveEventListener(c)
+			}
+		}()
+
+		// sleep waits for d, and reports whether ctx is still live
+		sleep := func(d time.Duration) bool {
+			select {
+			case <-time.After(d):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// back off reconnecting so a fleet of hosts doesn't hit a recovering
+		// daemon in lockstep
+		backoff := &utils.Backoff{Min: 10 * time.Second, Max: 60 * time.Second}
+
 		for {
+			if ctx.Err() != nil {
+				return
+			}
 
 			err := s.Ping()
 			if err != nil {
@@ -925,7 +1731,9 @@ func (s *ServiceRuntime) RegisterEvents(env, pool, hostIP string, listener chan
 					s.dockerClient.RemoveEventListener(c)
 					watching = false
 				}
-				time.Sleep(10 * time.Second)
+				if !sleep(backoff.Next()) {
+					return
+				}
 				continue
 
 			}
@@ -934,10 +1742,13 @@ func (s *ServiceRuntime) RegisterEvents(env, pool, hostIP string, listener chan
 				err = s.dockerClient.AddEventListener(c)
 				if err != nil && err != docker.ErrListenerAlreadyExists {
 					log.Printf("ERROR: Error registering docker event listener: %s", err)
-					time.Sleep(10 * time.Second)
+					if !sleep(backoff.Next()) {
+						return
+					}
 					continue
 				}
 				watching = true
+				backoff.Reset()
 			}
 
 			select {
@@ -972,16 +1783,31 @@ func (s *ServiceRuntime) RegisterEvents(env, pool, hostIP string, listener chan
 							continue
 						}
 
-						listener <- ContainerEvent{
+						if e.Status == "die" {
+							logExit(name, container)
+						}
+
+						event := ContainerEvent{
 							Status:              e.Status,
 							Container:           container,
 							ServiceRegistration: registration,
+							ExitCode:            container.State.ExitCode,
+							OOMKilled:           container.State.OOMKilled,
+						}
+
+						// a consumer that's stopped reading can't wedge the loop past ctx
+						select {
+						case listener <- event:
+						case <-ctx.Done():
+							return
 						}
 					}
 
 				}
 			case <-time.After(10 * time.Second):
 				// check for docker liveness
+			case <-ctx.Done():
+				return
 			}
 
 		}
@@ -1023,6 +1849,22 @@ func (s *ServiceRuntime) ManagedContainers() ([]*docker.Container, error) {
 	return apps, nil
 }
 
+// findInstance returns the managed container for an instance of app
+func (s *ServiceRuntime) findInstance(app string, instance int) (*docker.Container, error) {
+	containers, err := s.ManagedContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range containers {
+		env := s.EnvFor(c)
+		if env["GALAXY_APP"] == app && env["GALAXY_INSTANCE"] == strconv.Itoa(instance) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no running container for %s instance %d", app, instance)
+}
+
 func (s *ServiceRuntime) instanceIds(app, versionId string) ([]int, error) {
 	containers, err := s.ManagedContainers()
 	if err != nil {
@@ -1060,16 +1902,105 @@ func (s *ServiceRuntime) InstanceCount(app, versionId string) (int, error) {
 	return len(instances), err
 }
 
-func (s *ServiceRuntime) NextInstanceSlot(app, versionId string) (int, error) {
+// How often WaitForInstances checks the instance count
+var instancePollInterval = time.Second
+
+// WaitForInstances polls until at least want instances of app are running,
+// or returns an *InstanceTimeoutError once ctx is done or timeout passes. A
+// timeout of 0 waits as long as ctx does. If versionId is set, only instances
+// of that version are counted.
+func (s *ServiceRuntime) WaitForInstances(ctx context.Context, app, versionId string, want int, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(instancePollInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := s.InstanceCount(app, versionId)
+		if err != nil {
+			return err
+		}
+
+		if count >= want {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &InstanceTimeoutError{
+				App:       app,
+				VersionID: versionId,
+				Want:      want,
+				Count:     count,
+				Cause:     ctx.Err(),
+			}
+		}
+	}
+}
+
+// InstanceSlots returns the sorted instance numbers in use by running
+// containers of app, along with the slot the next instance would get. Slots
+// are reused: next is the lowest slot not in use, so with 0, 1 and 3 running
+// the next instance is 2. If versionId is set, only containers of that
+// version are counted.
+func (s *ServiceRuntime) InstanceSlots(app, versionId string) ([]int, int, error) {
 	instances, err := s.instanceIds(app, versionId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Ints(instances)
+	used := []int{}
+	for i, slot := range instances {
+		if i > 0 && slot == instances[i-1] {
+			continue
+		}
+		used = append(used, slot)
+	}
+
+	return used, utils.NextSlot(used), nil
+}
+
+func (s *ServiceRuntime) NextInstanceSlot(app, versionId string) (int, error) {
+	used, _, err := s.InstanceSlots(app, versionId)
 	if err != nil {
 		return 0, err
 	}
 
-	return utils.NextSlot(instances), nil
+	s.reserved.Lock()
+	defer s.reserved.Unlock()
+	return utils.NextSlot(s.reserved.withReserved(app, versionId, used)), nil
+}
+
+// substitutions are the variables app env values can refer to. They're the
+// ones galaxy sets on the container itself, plus DOCKER_IP.
+func (s *ServiceRuntime) substitutions(env string, appCfg config.App, instanceId int, publicDns string) map[string]string {
+	return map[string]string{
+		"ENV":             env,
+		"HOST_IP":         s.hostIP,
+		"DOCKER_IP":       s.dockerIP,
+		"PUBLIC_HOSTNAME": publicDns,
+		"GALAXY_APP":      appCfg.Name(),
+		"GALAXY_VERSION":  strconv.FormatInt(appCfg.ID(), 10),
+		"GALAXY_INSTANCE": strconv.Itoa(instanceId),
+	}
 }
 
-func (s ServiceRuntime) replaceVarEnv(in, hostIp string) string {
-	out := strings.Replace(in, "$HOST_IP", hostIp, -1)
-	return strings.Replace(out, "$DOCKER_IP", s.dockerIP, -1)
+var varRef = regexp.MustCompile(`\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// replaceVarEnv replaces each $NAME or ${NAME} in an env value with its value
+// in vars. Names that aren't in vars are left as they are.
+func replaceVarEnv(in string, vars map[string]string) string {
+	return varRef.ReplaceAllStringFunc(in, func(ref string) string {
+		name := strings.Trim(ref, "${}")
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return ref
+	})
 }
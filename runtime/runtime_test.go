@@ -0,0 +1,1273 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+)
+
+func TestNetworkMode(t *testing.T) {
+	s, client := newTestRuntime()
+	client.networks = append(client.networks, &docker.Network{ID: "1234", Name: "backend"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	for _, network := range []string{"", "host", "none", "bridge", "container:abcd", "backend"} {
+		appCfg.SetNetwork("web", network)
+		mode, err := s.networkMode(appCfg, "web")
+		if mode != network || err != nil {
+			t.Errorf("networkMode(%q) = %q, %v, want %q, %v", network, mode, err, network, nil)
+		}
+	}
+}
+
+func TestNetworkModeMissingNetwork(t *testing.T) {
+	s, _ := newTestRuntime()
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetNetwork("web", "backend")
+
+	if mode, err := s.networkMode(appCfg, "web"); mode != "" || err == nil {
+		t.Errorf("networkMode(%q) = %q, %v, want %q, an error", "backend", mode, err, "")
+	}
+}
+
+func staticIPRuntime() (*ServiceRuntime, *fakeDocker, config.App) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+	client.networks = append(client.networks, &docker.Network{
+		ID:   "1234",
+		Name: "backend",
+		IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "10.1.0.0/16"}}},
+	})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetNetwork("web", "backend")
+	appCfg.SetStaticIP("web", "10.1.0.5")
+	return s, client, appCfg
+}
+
+func TestStartStaticIP(t *testing.T) {
+	s, client, appCfg := staticIPRuntime()
+
+	var connected []docker.NetworkConnectionOptions
+	client.ConnectNetworkFunc = func(id string, opts docker.NetworkConnectionOptions) error {
+		if id != "backend" {
+			t.Errorf("ConnectNetwork() network = %s, want %s", id, "backend")
+		}
+		connected = append(connected, opts)
+		return nil
+	}
+
+	container, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatalf("Start() = %v, want %v", err, nil)
+	}
+
+	if len(connected) != 1 {
+		t.Fatalf("Start() connected %d times, want %d", len(connected), 1)
+	}
+
+	opts := connected[0]
+	if opts.Container != container.ID || opts.EndpointConfig == nil || opts.EndpointConfig.IPAMConfig == nil {
+		t.Fatalf("ConnectNetwork() = %+v, want %s with an IPAM config", opts, container.ID)
+	}
+
+	if ip := opts.EndpointConfig.IPAMConfig.IPv4Address; ip != "10.1.0.5" {
+		t.Errorf("ConnectNetwork() IPv4Address = %s, want %s", ip, "10.1.0.5")
+	}
+}
+
+func TestStartStaticIPInvalid(t *testing.T) {
+	for ip, network := range map[string]string{
+		"10.1.0":   "backend",
+		"fe80::1":  "backend",
+		"10.2.0.5": "backend",
+		"10.1.0.5": "bridge",
+	} {
+		s, client, appCfg := staticIPRuntime()
+		appCfg.SetNetwork("web", network)
+		appCfg.SetStaticIP("web", ip)
+
+		client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+			t.Errorf("CreateContainer() called with static IP %s on %s", ip, network)
+			return nil, errors.New("unexpected create")
+		}
+
+		if _, err := s.Start("dev", "web", appCfg); err == nil {
+			t.Errorf("Start() with static IP %s on %s = %v, want an error", ip, network, err)
+		}
+	}
+}
+
+func TestStartStaticIPConnectError(t *testing.T) {
+	s, client, appCfg := staticIPRuntime()
+
+	client.ConnectNetworkFunc = func(id string, opts docker.NetworkConnectionOptions) error {
+		return errors.New("address already in use")
+	}
+
+	if _, err := s.Start("dev", "web", appCfg); err == nil {
+		t.Fatalf("Start() = %v, want an error", err)
+	}
+
+	if len(client.containers) != 0 {
+		t.Errorf("Start() left %d containers, want %d", len(client.containers), 0)
+	}
+}
+
+func TestNetworkAllowsDNS(t *testing.T) {
+	for network, allowed := range map[string]bool{
+		"":               true,
+		"bridge":         true,
+		"backend":        true,
+		"host":           false,
+		"container:abcd": false,
+	} {
+		if networkAllowsDNS(network) != allowed {
+			t.Errorf("networkAllowsDNS(%q) = %t, want %t", network, !allowed, allowed)
+		}
+	}
+}
+
+// oldVersionRuntime returns a runtime with three containers running old
+// versions of the web app, and one running the current version.
+func oldVersionRuntime() (*ServiceRuntime, *fakeDocker, config.App) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "img1"}, &docker.Image{ID: "img2"})
+
+	appCfg := config.NewAppConfig("web", "web:2")
+	appCfg.SetVersionID("img2")
+	current := strconv.FormatInt(appCfg.ID(), 10)
+
+	client.addContainer("current_container", "web", current, 0).Image = "img2"
+	client.addContainer("old_image_container", "web", current, 1).Image = "img1"
+	client.addContainer("old_version_container", "web", "1", 2).Image = "img2"
+	client.addContainer("no_version_container", "web", "", 3).Image = "img1"
+	client.addContainer("other_container", "api", "1", 0).Image = "img1"
+	return s, client, appCfg
+}
+
+func containerIDs(containers []*docker.Container) []string {
+	ids := []string{}
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestContainersToStop(t *testing.T) {
+	s, _, appCfg := oldVersionRuntime()
+
+	containers, err := s.containersToStop(appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"old_image_container", "old_version_container", "no_version_container"}
+	if ids := containerIDs(containers); !reflect.DeepEqual(ids, want) {
+		t.Errorf("containersToStop() = %v, want %v", ids, want)
+	}
+}
+
+func TestContainersToStopNoVersionID(t *testing.T) {
+	s, _, appCfg := oldVersionRuntime()
+	// without an image id only the config version is compared
+	appCfg.SetVersionID("")
+
+	containers, err := s.containersToStop(appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all but other and no_version are on a different config version now
+	want := []string{"current_container", "old_image_container", "old_version_container"}
+	if ids := containerIDs(containers); !reflect.DeepEqual(ids, want) {
+		t.Errorf("containersToStop() = %v, want %v", ids, want)
+	}
+}
+
+func TestStopOldVersionLimit(t *testing.T) {
+	for limit, want := range map[int]int{-1: 3, 0: 3, 1: 1, 2: 2, 3: 3, 10: 3} {
+		s, client, appCfg := oldVersionRuntime()
+
+		stopped := 0
+		client.StopContainerFunc = func(id string, timeout uint) error {
+			stopped++
+			return nil
+		}
+
+		containers, err := s.StopOldVersion(appCfg, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stopped != want || len(containers) != want {
+			t.Errorf("StopOldVersion(%d) stopped %d, returned %d, want %d", limit, stopped, len(containers), want)
+		}
+	}
+}
+
+// failOnStop makes any call stopping or removing a container fail the test
+func failOnStop(t *testing.T, client *fakeDocker) {
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		t.Errorf("StopContainer(%s) called in a dry run", id)
+		return nil
+	}
+	client.RemoveContainerFunc = func(opts docker.RemoveContainerOptions) error {
+		t.Errorf("RemoveContainer(%s) called in a dry run", opts.ID)
+		return nil
+	}
+}
+
+func TestStopOldVersionDryRun(t *testing.T) {
+	for limit, want := range map[int][]string{
+		0: {"old_image_container", "old_version_container", "no_version_container"},
+		2: {"old_image_container", "old_version_container"},
+	} {
+		s, client, appCfg := oldVersionRuntime()
+		s.DryRun = true
+		failOnStop(t, client)
+
+		containers, err := s.StopOldVersion(appCfg, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := containerIDs(containers); !reflect.DeepEqual(ids, want) {
+			t.Errorf("StopOldVersion(%d) = %v, want %v", limit, ids, want)
+		}
+
+		for _, c := range client.containers {
+			if !c.State.Running {
+				t.Errorf("StopOldVersion(%d) stopped %s in a dry run", limit, c.ID)
+			}
+		}
+	}
+}
+
+func TestStopAllDryRun(t *testing.T) {
+	s, client, _ := oldVersionRuntime()
+	s.DryRun = true
+	failOnStop(t, client)
+
+	containers, err := s.StopAll("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(containers) != len(client.containers) {
+		t.Errorf("StopAll() = %d containers, want %d", len(containers), len(client.containers))
+	}
+}
+
+func TestStopUnassignedDryRun(t *testing.T) {
+	s, client, _ := oldVersionRuntime()
+	s.configStore = &config.Store{Backend: config.NewMemoryBackend()}
+	s.DryRun = true
+	failOnStop(t, client)
+
+	// nothing is assigned, so every container would be stopped
+	containers, err := s.StopUnassigned("dev", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, want := containerIDs(containers), containerIDs(client.containers); !reflect.DeepEqual(ids, want) {
+		t.Errorf("StopUnassigned() = %v, want %v", ids, want)
+	}
+}
+
+func TestStopDryRunSkipsBlacklisted(t *testing.T) {
+	s, client, appCfg := oldVersionRuntime()
+	s.configStore = &config.Store{Backend: config.NewMemoryBackend()}
+	s.DryRun = true
+	failOnStop(t, client)
+
+	blacklistedContainers.add("old_image_container")
+	defer blacklistedContainers.remove("old_image_container")
+
+	for name, stop := range map[string]func() ([]*docker.Container, error){
+		"StopOldVersion": func() ([]*docker.Container, error) { return s.StopOldVersion(appCfg, 0) },
+		"StopAll":        func() ([]*docker.Container, error) { return s.StopAll("dev") },
+		"StopUnassigned": func() ([]*docker.Container, error) { return s.StopUnassigned("dev", "web") },
+	} {
+		containers, err := stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(containers) == 0 {
+			t.Errorf("%s() = no containers, want the ones that aren't blacklisted", name)
+		}
+
+		for _, id := range containerIDs(containers) {
+			if id == "old_image_container" {
+				t.Errorf("%s() = %v, want no blacklisted %s", name, containerIDs(containers), id)
+			}
+		}
+	}
+}
+
+func TestRunCommandCleansUpSignalHandling(t *testing.T) {
+	s, client := newTestRuntime()
+	client.InspectImageFunc = func(name string) (*docker.Image, error) {
+		return &docker.Image{ID: "img1"}, nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	// warm up anything started lazily
+	if _, err := s.RunCommand("dev", appCfg, []string{"true"}); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		if _, err := s.RunCommand("dev", appCfg, []string{"true"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("RunCommand() leaked goroutines: %d running, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(client.containers) != 0 {
+		t.Errorf("RunCommand() left %d containers, want %d", len(client.containers), 0)
+	}
+}
+
+func TestGetImageByNameListError(t *testing.T) {
+	s, client := newTestRuntime()
+
+	listErr := errors.New("docker is down")
+	client.ListImagesFunc = func(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+		return nil, listErr
+	}
+
+	image, err := s.GetImageByName("web:1")
+	if image != nil || err != listErr {
+		t.Errorf("GetImageByName() = %v, %v, want %v, %v", image, err, nil, listErr)
+	}
+}
+
+func TestGetImageByName(t *testing.T) {
+	s, client := newTestRuntime()
+
+	client.ListImagesFunc = func(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+		return []docker.APIImages{
+			{ID: "img1", RepoTags: []string{"web:1"}},
+			{ID: "img2", RepoTags: []string{"web:2", "web:latest"}},
+		}, nil
+	}
+
+	image, err := s.GetImageByName("web:latest")
+	if image == nil || image.ID != "img2" || err != nil {
+		t.Errorf("GetImageByName() = %v, %v, want %s, %v", image, err, "img2", nil)
+	}
+}
+
+func TestAppEnvFile(t *testing.T) {
+	s, _ := newTestRuntime()
+
+	f, err := ioutil.TempFile("", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("DATABASE_URL=postgres://secret@db/app\nWORKERS=2\nENV=nope\n")
+	f.Close()
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.EnvSet("WORKERS", "4")
+	appCfg.SetEnvFile("web", f.Name())
+
+	envVars, err := s.appEnv("dev", "web", appCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(envVars)
+
+	want := []string{"DATABASE_URL=postgres://secret@db/app", "ENV=dev", "WORKERS=4"}
+	if !reflect.DeepEqual(envVars, want) {
+		t.Errorf("appEnv() = %v, want %v", envVars, want)
+	}
+}
+
+func TestAppEnvFileMissing(t *testing.T) {
+	s, _ := newTestRuntime()
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetEnvFile("web", "/nonexistent/web.env")
+
+	if _, err := s.appEnv("dev", "web", appCfg, nil); err == nil {
+		t.Errorf("appEnv() = %v, want an error", err)
+	}
+}
+
+func TestDockerCertPath(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"HOME": "/home/galaxy"}, ""},
+		{map[string]string{"DOCKER_CERT_PATH": "/etc/docker/certs"}, "/etc/docker/certs"},
+		{map[string]string{"DOCKER_TLS_VERIFY": "1", "HOME": "/home/galaxy"}, "/home/galaxy/.docker"},
+		{map[string]string{"DOCKER_TLS_VERIFY": "1", "DOCKER_CERT_PATH": "/etc/docker/certs", "HOME": "/home/galaxy"}, "/etc/docker/certs"},
+	}
+
+	for _, c := range cases {
+		getenv := func(key string) string {
+			return c.env[key]
+		}
+
+		if got := dockerCertPath(getenv); got != c.want {
+			t.Errorf("dockerCertPath(%v) = %q, want %q", c.env, got, c.want)
+		}
+	}
+}
+
+func TestEnvKey(t *testing.T) {
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	valid := map[string]string{
+		"DATABASE_URL": "DATABASE_URL",
+		"workers":      "WORKERS",
+		"_Private2":    "_PRIVATE2",
+	}
+	for key, want := range valid {
+		if got, err := envKey(appCfg, key); got != want || err != nil {
+			t.Errorf("envKey(%q) = %q, %v, want %q, %v", key, got, err, want, nil)
+		}
+	}
+
+	for _, key := range []string{"", "new-relic", "app.name", "2FA", "KEY WITH SPACE"} {
+		if got, err := envKey(appCfg, key); err == nil {
+			t.Errorf("envKey(%q) = %q, %v, want an error", key, got, err)
+		}
+	}
+}
+
+func TestAppEnvInvalidKey(t *testing.T) {
+	s, _ := newTestRuntime()
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.EnvSet("workers", "4")
+	appCfg.EnvSet("newrelic.license", "secret")
+
+	_, err := s.appEnv("dev", "web", appCfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "NEWRELIC.LICENSE") || !strings.Contains(err.Error(), "web") {
+		t.Errorf("appEnv() = %v, want an error naming the key and app", err)
+	}
+}
+
+func TestRunCommandInvalidEnvKey(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		t.Errorf("CreateContainer() called with an invalid env")
+		return nil, nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.EnvSet("bad-key", "1")
+
+	if _, _, _, err := s.RunCommandCapture("dev", appCfg, []string{"true"}); err == nil {
+		t.Errorf("RunCommandCapture() = %v, want an error", err)
+	}
+}
+
+func TestWaitForInstances(t *testing.T) {
+	defer func(interval time.Duration) { instancePollInterval = interval }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+
+	s, client := newTestRuntime()
+	for i := 0; i < 3; i++ {
+		client.addContainer("web_container_"+strconv.Itoa(i), "web", "2", i).State.Running = false
+	}
+	client.addContainer("web_container_old", "web", "1", 3)
+
+	// one more instance comes up on every poll
+	calls := 0
+	client.ListContainersFunc = func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+		if calls < 3 {
+			client.containers[calls].State.Running = true
+		}
+		calls++
+
+		containers := []docker.APIContainers{}
+		for _, c := range client.containers {
+			containers = append(containers, docker.APIContainers{ID: c.ID})
+		}
+		return containers, nil
+	}
+
+	if err := s.WaitForInstances(context.Background(), "web", "2", 2, time.Second); err != nil {
+		t.Fatalf("WaitForInstances() = %v, want %v", err, nil)
+	}
+
+	if calls != 2 {
+		t.Errorf("WaitForInstances() polled %d times, want %d", calls, 2)
+	}
+}
+
+func TestWaitForInstancesTimeout(t *testing.T) {
+	defer func(interval time.Duration) { instancePollInterval = interval }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+
+	s, client := newTestRuntime()
+	client.addContainer("web_container_0", "web", "2", 0)
+	client.addContainer("web_container_1", "web", "1", 1)
+
+	err := s.WaitForInstances(context.Background(), "web", "2", 2, 20*time.Millisecond)
+	timeoutErr, ok := err.(*InstanceTimeoutError)
+	if !ok {
+		t.Fatalf("WaitForInstances() = %v, want an *InstanceTimeoutError", err)
+	}
+
+	if timeoutErr.Count != 1 || timeoutErr.Want != 2 || timeoutErr.Cause != context.DeadlineExceeded {
+		t.Errorf("WaitForInstances() = %+v, want count %d of %d, %v", timeoutErr, 1, 2, context.DeadlineExceeded)
+	}
+
+	// the caller giving up ends the wait too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.WaitForInstances(ctx, "web", "", 3, 0)
+	if timeoutErr, ok := err.(*InstanceTimeoutError); !ok || timeoutErr.Count != 2 || timeoutErr.Cause != context.Canceled {
+		t.Errorf("WaitForInstances() cancelled = %v, want count %d, %v", err, 2, context.Canceled)
+	}
+}
+
+func TestInstanceSlots(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_container_3", "web", "2", 3)
+	client.addContainer("web_container_0", "web", "2", 0)
+	client.addContainer("web_container_1", "web", "1", 1)
+	client.addContainer("api_container_2", "api", "2", 2)
+
+	used, next, err := s.InstanceSlots("web", "")
+	if !reflect.DeepEqual(used, []int{0, 1, 3}) || next != 2 || err != nil {
+		t.Errorf("InstanceSlots(%q) = %v, %d, %v, want %v, %d, %v", "", used, next, err, []int{0, 1, 3}, 2, nil)
+	}
+
+	used, next, err = s.InstanceSlots("web", "2")
+	if !reflect.DeepEqual(used, []int{0, 3}) || next != 1 || err != nil {
+		t.Errorf("InstanceSlots(%q) = %v, %d, %v, want %v, %d, %v", "2", used, next, err, []int{0, 3}, 1, nil)
+	}
+
+	used, next, err = s.InstanceSlots("worker", "")
+	if len(used) != 0 || next != 0 || err != nil {
+		t.Errorf("InstanceSlots(%q) = %v, %d, %v, want %v, %d, %v", "worker", used, next, err, []int{}, 0, nil)
+	}
+}
+
+func TestStopContainerGracePeriod(t *testing.T) {
+	s, client := newTestRuntime()
+	configured := client.addContainer("configured_container", "web", "1", 0)
+	configured.Config.Env = append(configured.Config.Env, "GALAXY_STOP_TIMEOUT=30")
+	unset := client.addContainer("default_container", "web", "1", 1)
+
+	var timeouts []uint
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		timeouts = append(timeouts, timeout)
+		return nil
+	}
+
+	s.stopContainer(configured)
+	s.stopContainer(unset)
+
+	want := []uint{30, defaultStopTimeout}
+	if !reflect.DeepEqual(timeouts, want) {
+		t.Errorf("stopContainer() timeouts = %v, want %v", timeouts, want)
+	}
+}
+
+func TestStopContainerWatchdog(t *testing.T) {
+	buffer := stopWatchdogBuffer
+	stopWatchdogBuffer = 50 * time.Millisecond
+	defer func() { stopWatchdogBuffer = buffer }()
+
+	s, client := newTestRuntime()
+	container := client.addContainer("zombie_container", "web", "1", 0)
+	container.Config.Env = append(container.Config.Env, "GALAXY_STOP_TIMEOUT=1")
+	defer blacklistedContainers.remove(container.ID)
+
+	hung := make(chan struct{})
+	defer close(hung)
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		<-hung
+		return nil
+	}
+
+	start := time.Now()
+	s.stopContainer(container)
+	elapsed := time.Since(start)
+
+	if elapsed < time.Second+stopWatchdogBuffer || elapsed > 2*time.Second {
+		t.Errorf("stopContainer() gave up after %s, want %s", elapsed, time.Second+stopWatchdogBuffer)
+	}
+
+	if !blacklistedContainers.contains(container.ID) {
+		t.Errorf("stopContainer() didn't blacklist %s", container.ID)
+	}
+}
+
+func TestRegisterEventsExitCode(t *testing.T) {
+	s, client := newTestRuntime()
+	container := client.addContainer("crashed_container_0000", "web", "1", 0)
+	container.State = docker.State{ExitCode: 137, OOMKilled: true}
+
+	backend := config.NewMemoryBackend()
+	backend.GetServiceRegistrationFunc = func(env, pool, hostIP, name, containerID string) (*config.ServiceRegistration, error) {
+		return &config.ServiceRegistration{Name: name, ContainerID: containerID}, nil
+	}
+	s.configStore = &config.Store{Backend: backend}
+
+	events := make(chan chan<- *docker.APIEvents, 1)
+	client.AddEventListenerFunc = func(listener chan<- *docker.APIEvents) error {
+		events <- listener
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener := make(chan ContainerEvent)
+	if err := s.RegisterEvents(ctx, "dev", "web", "127.0.0.1", listener); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-events:
+		c <- &docker.APIEvents{Status: "die", ID: container.ID}
+	case <-time.After(time.Second):
+		t.Fatal("RegisterEvents() didn't add an event listener")
+	}
+
+	select {
+	case e := <-listener:
+		if e.Status != "die" || e.ExitCode != 137 || !e.OOMKilled {
+			t.Errorf("RegisterEvents() = %s exit %d oom %t, want %s exit %d oom %t",
+				e.Status, e.ExitCode, e.OOMKilled, "die", 137, true)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RegisterEvents() didn't send the die event")
+	}
+}
+
+func TestRegisterEventsCancel(t *testing.T) {
+	s, client := newTestRuntime()
+	container := client.addContainer("web_container_0000", "web", "1", 0)
+
+	backend := config.NewMemoryBackend()
+	backend.GetServiceRegistrationFunc = func(env, pool, hostIP, name, containerID string) (*config.ServiceRegistration, error) {
+		return &config.ServiceRegistration{Name: name, ContainerID: containerID}, nil
+	}
+	s.configStore = &config.Store{Backend: backend}
+
+	events := make(chan chan<- *docker.APIEvents, 1)
+	client.AddEventListenerFunc = func(listener chan<- *docker.APIEvents) error {
+		events <- listener
+		return nil
+	}
+
+	removed := make(chan struct{})
+	client.RemoveEventListenerFunc = func(listener chan *docker.APIEvents) error {
+		close(removed)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// nothing reads the listener, so the event send blocks until cancel
+	listener := make(chan ContainerEvent)
+	if err := s.RegisterEvents(ctx, "dev", "web", "127.0.0.1", listener); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-events:
+		c <- &docker.APIEvents{Status: "start", ID: container.ID}
+	case <-time.After(time.Second):
+		t.Fatal("RegisterEvents() didn't add an event listener")
+	}
+
+	cancel()
+
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("RegisterEvents() didn't remove its event listener after cancel")
+	}
+}
+
+func TestReplaceVarEnv(t *testing.T) {
+	vars := map[string]string{
+		"HOST_IP":         "10.0.0.1",
+		"PUBLIC_HOSTNAME": "web.example.com",
+		"GALAXY_INSTANCE": "3",
+	}
+
+	for in, want := range map[string]string{
+		"":                                  "",
+		"plain":                             "plain",
+		"$HOST_IP":                          "10.0.0.1",
+		"$PUBLIC_HOSTNAME:9000":             "web.example.com:9000",
+		"$HOST_IP:$GALAXY_INSTANCE":         "10.0.0.1:3",
+		"${HOST_IP}0":                       "10.0.0.10",
+		"$FOO/$HOST_IP":                     "$FOO/10.0.0.1",
+		"${FOO}":                            "${FOO}",
+		"worker-${GALAXY_INSTANCE}.$FOO$":   "worker-3.$FOO$",
+		"http://$PUBLIC_HOSTNAME/$HOST_IPS": "http://web.example.com/$HOST_IPS",
+	} {
+		if got := replaceVarEnv(in, vars); got != want {
+			t.Errorf("replaceVarEnv(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStartSubstitutesVars(t *testing.T) {
+	s, client := newTestRuntime()
+	s.hostIP = "10.0.0.1"
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.EnvSet("ADVERTISE_ADDR", "$PUBLIC_HOSTNAME:9000")
+	appCfg.EnvSet("NODE", "$GALAXY_APP-$GALAXY_INSTANCE@$HOST_IP")
+
+	container, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := s.EnvFor(container)
+	if env["ADVERTISE_ADDR"] != "127.0.0.1:9000" {
+		t.Errorf("ADVERTISE_ADDR = %q, want %q", env["ADVERTISE_ADDR"], "127.0.0.1:9000")
+	}
+
+	if env["NODE"] != "web-0@10.0.0.1" {
+		t.Errorf("NODE = %q, want %q", env["NODE"], "web-0@10.0.0.1")
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	for _, test := range []struct {
+		mem, swap  string
+		memBytes   int64
+		swapBytes  int64
+		shouldFail bool
+	}{
+		{"", "", 0, 0, false},
+		{"1G", "", 1 << 30, 0, false},
+		{"512m", "1g", 512 << 20, 1 << 30, false},
+		{"1g", "1024M", 1 << 30, 1 << 30, false},
+		{"", "1g", 0, 0, true},
+		{"1g", "512m", 0, 0, true},
+		{"1x", "", 0, 0, true},
+		{"1g", "lots", 0, 0, true},
+	} {
+		appCfg := config.NewAppConfig("web", "web:1")
+		appCfg.SetMemory("web", test.mem)
+		appCfg.SetMemorySwap("web", test.swap)
+
+		mem, swap, err := memoryLimits(appCfg, "web")
+		if mem != test.memBytes || swap != test.swapBytes || (err != nil) != test.shouldFail {
+			t.Errorf("memoryLimits(%q, %q) = %d, %d, %v, want %d, %d, error %t",
+				test.mem, test.swap, mem, swap, err, test.memBytes, test.swapBytes, test.shouldFail)
+		}
+	}
+}
+
+func TestStartMemoryLimits(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	var hostConfig *docker.HostConfig
+	client.StartContainerFunc = func(id string, hc *docker.HostConfig) error {
+		hostConfig = hc
+		return nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetMemory("web", "512M")
+	appCfg.SetMemorySwap("web", "1g")
+
+	container, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if container.Config.Memory != 512<<20 || hostConfig.Memory != 512<<20 || hostConfig.MemorySwap != 1<<30 {
+		t.Errorf("Start() memory = %d, %d, swap %d, want %d, %d, %d",
+			container.Config.Memory, hostConfig.Memory, hostConfig.MemorySwap, 512<<20, 512<<20, 1<<30)
+	}
+}
+
+// startIfNotRunningRuntime returns a runtime where the web:1 tag points at
+// image img2.
+func startIfNotRunningRuntime() (*ServiceRuntime, *fakeDocker, config.App) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "img1"}, &docker.Image{ID: "img2"})
+	client.InspectImageFunc = func(name string) (*docker.Image, error) {
+		if name == "web:1" {
+			name = "img2"
+		}
+		for _, i := range client.images {
+			if i.ID == name {
+				return i, nil
+			}
+		}
+		return nil, docker.ErrNoSuchImage
+	}
+	return s, client, config.NewAppConfig("web", "web:1")
+}
+
+func TestStartIfNotRunningAlreadyRunning(t *testing.T) {
+	s, client, appCfg := startIfNotRunningRuntime()
+	running := client.addContainer("running_container", "web", strconv.FormatInt(appCfg.ID(), 10), 0)
+	running.Image = "img2"
+
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		t.Errorf("CreateContainer(%s) called for a running app", opts.Name)
+		return nil, errors.New("unexpected create")
+	}
+
+	started, container, err := s.StartIfNotRunning("dev", "web", appCfg)
+	if started || container != running || err != nil {
+		t.Errorf("StartIfNotRunning() = %t, %v, %v, want %t, %s, %v", started, container, err, false, running.ID, nil)
+	}
+}
+
+func TestStartIfNotRunningImageChanged(t *testing.T) {
+	s, client, appCfg := startIfNotRunningRuntime()
+	// started before the web:1 tag moved to img2
+	old := client.addContainer("old_image_container", "web", strconv.FormatInt(appCfg.ID(), 10), 0)
+	old.Image = "img1"
+
+	started, container, err := s.StartIfNotRunning("dev", "web", appCfg)
+	if !started || container == nil || container == old || err != nil {
+		t.Errorf("StartIfNotRunning() = %t, %v, %v, want %t, a new container, %v", started, container, err, true, nil)
+	}
+}
+
+func TestStartIfNotRunningNotRunning(t *testing.T) {
+	s, client, appCfg := startIfNotRunningRuntime()
+	// other apps and config versions don't count
+	client.addContainer("other_app_container", "api", strconv.FormatInt(appCfg.ID(), 10), 0).Image = "img2"
+	client.addContainer("other_version_container", "web", "0", 0).Image = "img2"
+
+	started, container, err := s.StartIfNotRunning("dev", "web", appCfg)
+	if !started || container == nil || err != nil {
+		t.Fatalf("StartIfNotRunning() = %t, %v, %v, want %t, a new container, %v", started, container, err, true, nil)
+	}
+
+	if env := s.EnvFor(container); env["GALAXY_APP"] != "web" {
+		t.Errorf("StartIfNotRunning() started %s, want %s", env["GALAXY_APP"], "web")
+	}
+}
+
+func TestStartServiceMetaLabels(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetServiceMeta("web", map[string]string{"role": "api"})
+
+	container, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{config.MetaLabelPrefix + "role": "api"}
+	if !reflect.DeepEqual(container.Config.Labels, want) {
+		t.Errorf("Start() labels = %v, want %v", container.Config.Labels, want)
+	}
+}
+
+func TestRunCommandCapture(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	var cmd []string
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		cmd = opts.Config.Cmd
+		container := &docker.Container{ID: "migrate_container", Config: opts.Config}
+		client.containers = append(client.containers, container)
+		return container, nil
+	}
+	client.AttachToContainerFunc = func(opts docker.AttachToContainerOptions) error {
+		opts.OutputStream.Write([]byte("migrating\n"))
+		opts.ErrorStream.Write([]byte("table exists\n"))
+		return nil
+	}
+	client.WaitContainerFunc = func(id string) (int, error) {
+		return 3, nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	stdout, stderr, exitCode, err := s.RunCommandCapture("dev", appCfg, []string{"rake", "db:migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(stdout) != "migrating\n" || string(stderr) != "table exists\n" || exitCode != 3 {
+		t.Errorf("RunCommandCapture() = %q, %q, %d, want %q, %q, %d",
+			stdout, stderr, exitCode, "migrating\n", "table exists\n", 3)
+	}
+
+	if want := []string{"/bin/sh", "-c", "rake db:migrate"}; !reflect.DeepEqual(cmd, want) {
+		t.Errorf("RunCommandCapture() cmd = %v, want %v", cmd, want)
+	}
+
+	if len(client.containers) != 0 {
+		t.Errorf("RunCommandCapture() left %d containers, want %d", len(client.containers), 0)
+	}
+}
+
+func TestRunCommandCaptureAttachError(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	attachErr := errors.New("connection reset")
+	client.AttachToContainerFunc = func(opts docker.AttachToContainerOptions) error {
+		return attachErr
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	if _, _, _, err := s.RunCommandCapture("dev", appCfg, []string{"true"}); err != attachErr {
+		t.Errorf("RunCommandCapture() = %v, want %v", err, attachErr)
+	}
+
+	if len(client.containers) != 0 {
+		t.Errorf("RunCommandCapture() left %d containers, want %d", len(client.containers), 0)
+	}
+}
+
+func TestPullImageMirror(t *testing.T) {
+	s, client := newTestRuntime()
+	s.registryMirror = registryHost("https://mirror.example.com:5000/")
+
+	for _, tc := range []struct {
+		version, pulled, tagged string
+	}{
+		{"redis:3", "mirror.example.com:5000/library/redis", "mirror.example.com:5000/library/redis:3"},
+		{"litl/web:1", "mirror.example.com:5000/litl/web", "mirror.example.com:5000/litl/web:1"},
+		// an explicit registry isn't mirrored
+		{"quay.io/litl/web:1", "quay.io/litl/web", ""},
+	} {
+		var pulled docker.PullImageOptions
+		client.PullImageFunc = func(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+			pulled = opts
+			client.images = append(client.images, &docker.Image{ID: opts.Repository + ":" + opts.Tag})
+			return nil
+		}
+
+		tagged := ""
+		client.TagImageFunc = func(name string, opts docker.TagImageOptions) error {
+			tagged = name
+			client.images = append(client.images, &docker.Image{ID: opts.Repo + ":" + opts.Tag})
+			return nil
+		}
+
+		image, err := s.PullImage(tc.version, "")
+		if err != nil {
+			t.Fatalf("PullImage(%s) = %v, want %v", tc.version, err, nil)
+		}
+
+		if pulled.Repository != tc.pulled {
+			t.Errorf("PullImage(%s) pulled %s, want %s", tc.version, pulled.Repository, tc.pulled)
+		}
+
+		if tagged != tc.tagged {
+			t.Errorf("PullImage(%s) tagged %q, want %q", tc.version, tagged, tc.tagged)
+		}
+
+		if image == nil || image.ID != tc.version {
+			t.Errorf("PullImage(%s) = %v, want image %s", tc.version, image, tc.version)
+		}
+	}
+}
+
+func TestFindAuthMirror(t *testing.T) {
+	defer func(f func() (*docker.AuthConfigurations, error)) { dockerAuths = f }(dockerAuths)
+	dockerAuths = func() (*docker.AuthConfigurations, error) {
+		return &docker.AuthConfigurations{Configs: map[string]docker.AuthConfiguration{
+			"https://mirror.example.com:5000/v1/": {Username: "mirror"},
+			"https://index.docker.io/v1/":         {Username: "index"},
+			"https://index.example.com/v1/":       {Username: "custom"},
+		}}, nil
+	}
+
+	s, client := newTestRuntime()
+	s.registryMirror = "mirror.example.com:5000"
+
+	var auth docker.AuthConfiguration
+	client.PullImageFunc = func(opts docker.PullImageOptions, a docker.AuthConfiguration) error {
+		auth = a
+		return nil
+	}
+	s.PullImage("redis:3", "")
+
+	if auth.Username != "mirror" {
+		t.Errorf("PullImage() auth = %q, want %q", auth.Username, "mirror")
+	}
+
+	if auth, _ := s.findAuth("registry.example.com"); auth.Username != "index" {
+		t.Errorf("findAuth() = %q, want %q", auth.Username, "index")
+	}
+
+	s.IndexServer = "https://index.example.com/v1/"
+	if auth, _ := s.findAuth("registry.example.com"); auth.Username != "custom" {
+		t.Errorf("findAuth() with IndexServer = %q, want %q", auth.Username, "custom")
+	}
+}
+
+func TestRunningApps(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_1_container", "web", "web:1", 1).Image = "img1"
+	client.addContainer("web_0_container", "web", "web:1", 0).Image = "img1"
+	client.addContainer("worker_container", "worker", "worker:2", 0).Image = "img2"
+	client.addContainer("api_0_container", "api", "api:3", 0).Image = "img3"
+	client.addContainer("stopped_container", "api", "api:3", 1).State.Running = false
+
+	backend := config.NewMemoryBackend()
+	backend.ListPoolsFunc = func(env string) ([]string, error) {
+		return []string{"web", "worker"}, nil
+	}
+	backend.ListAssignmentsFunc = func(env, pool string) ([]string, error) {
+		if pool == "web" {
+			return []string{"web", "api"}, nil
+		}
+		return []string{"worker"}, nil
+	}
+	s.configStore = &config.Store{Backend: backend}
+
+	apps, err := s.RunningApps("dev", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RunningApp{
+		{Name: "api", Version: "api:3", Instance: 0, ImageID: "img3", ContainerID: "api_0_container"},
+		{Name: "web", Version: "web:1", Instance: 0, ImageID: "img1", ContainerID: "web_0_container"},
+		{Name: "web", Version: "web:1", Instance: 1, ImageID: "img1", ContainerID: "web_1_container"},
+	}
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("RunningApps() = %+v, want %+v", apps, want)
+	}
+}
+
+func TestRunningAppsAssignmentError(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_0_container", "web", "web:1", 0)
+
+	backend := config.NewMemoryBackend()
+	backend.ListPoolsFunc = func(env string) ([]string, error) {
+		return nil, errors.New("connection refused")
+	}
+	s.configStore = &config.Store{Backend: backend}
+
+	if _, err := s.RunningApps("dev", "web"); err == nil {
+		t.Errorf("RunningApps() = %v, want an error", err)
+	}
+}
+
+func TestStartReadOnlyRootfs(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	var hostConfig *docker.HostConfig
+	client.StartContainerFunc = func(id string, config *docker.HostConfig) error {
+		hostConfig = config
+		return nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetReadOnlyRootfs("web", true)
+	appCfg.SetTmpfs("web", map[string]string{"/tmp": "rw,size=64m", "/run": ""})
+
+	if _, err := s.Start("dev", "web", appCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hostConfig.ReadonlyRootfs {
+		t.Errorf("Start() ReadonlyRootfs = %t, want %t", hostConfig.ReadonlyRootfs, true)
+	}
+
+	want := map[string]string{"/tmp": "rw,size=64m", "/run": ""}
+	if !reflect.DeepEqual(hostConfig.Tmpfs, want) {
+		t.Errorf("Start() Tmpfs = %v, want %v", hostConfig.Tmpfs, want)
+	}
+
+	if args := tmpfsArgs(want); !reflect.DeepEqual(args, []string{"/run", "/tmp:rw,size=64m"}) {
+		t.Errorf("tmpfsArgs() = %v, want %v", args, []string{"/run", "/tmp:rw,size=64m"})
+	}
+}
+
+func TestStartNoTmpfs(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	var hostConfig *docker.HostConfig
+	client.StartContainerFunc = func(id string, config *docker.HostConfig) error {
+		hostConfig = config
+		return nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetTmpfs("web", map[string]string{})
+
+	if _, err := s.Start("dev", "web", appCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if hostConfig.ReadonlyRootfs || hostConfig.Tmpfs != nil {
+		t.Errorf("Start() = ReadonlyRootfs %t Tmpfs %v, want %t %v", hostConfig.ReadonlyRootfs, hostConfig.Tmpfs, false, nil)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	s, client := newTestRuntime()
+	s.pingTimeout = 10 * time.Millisecond
+
+	// a hung daemon: the request only ends when its context does
+	client.PingFunc = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	start := time.Now()
+	if err := s.Ping(); err != ErrPingTimeout {
+		t.Errorf("Ping() = %v, want %v", err, ErrPingTimeout)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Ping() took %s, want about %s", elapsed, s.pingTimeout)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s, client := newTestRuntime()
+
+	pingErr := errors.New("connection refused")
+	client.PingFunc = func(ctx context.Context) error {
+		return pingErr
+	}
+
+	if err := s.Ping(); err != pingErr {
+		t.Errorf("Ping() = %v, want %v", err, pingErr)
+	}
+}
+
+func TestContainerNameTemplate(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+	s.ContainerNameTemplate = "{{.Env}}.{{.Pool}}.{{.App}}.{{.Instance}}"
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	appCfg.SetVersionID("web:1")
+
+	started, err := s.Start("dev", "web", appCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if started.Name != "/dev.web.web.0" {
+		t.Errorf("Start() name = %s, want %s", started.Name, "/dev.web.web.0")
+	}
+
+	if err := s.Stop(appCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if started.State.Running {
+		t.Errorf("Stop() left %s running", started.Name)
+	}
+
+	// starting again finds the stopped container by the same name
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		t.Errorf("CreateContainer(%s) called for an existing container", opts.Name)
+		return nil, errors.New("unexpected create")
+	}
+	again, err := s.Start("dev", "web", appCfg)
+	if err != nil || again != started {
+		t.Errorf("Start() again = %v, %v, want %s", again, err, started.ID)
+	}
+}
+
+func TestContainerNameDefault(t *testing.T) {
+	s, _ := newTestRuntime()
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	name, err := s.ContainerName("dev", "web", appCfg, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := appCfg.ContainerName() + ".2"; name != want {
+		t.Errorf("ContainerName() = %s, want %s", name, want)
+	}
+}
+
+func TestContainerNameBadTemplate(t *testing.T) {
+	s, _ := newTestRuntime()
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	for _, tmpl := range []string{"{{.Nope}}", "{{.App", "{{.App}}/{{.Instance}}"} {
+		s.ContainerNameTemplate = tmpl
+		if name, err := s.ContainerName("dev", "web", appCfg, 0); err == nil {
+			t.Errorf("ContainerName() with %q = %s, want an error", tmpl, name)
+		}
+	}
+}
+
+func TestRegisterApp(t *testing.T) {
+	s, client := newTestRuntime()
+	s.configStore = &config.Store{Backend: config.NewMemoryBackend()}
+	for _, c := range []*docker.Container{
+		client.addContainer("web_container_0", "web", "web:1", 0),
+		client.addContainer("api_container_0", "api", "api:1", 0),
+		client.addContainer("web_container_1", "web", "web:1", 1),
+	} {
+		c.NetworkSettings = &docker.NetworkSettings{}
+	}
+
+	// none of the apps are assigned, so StopUnassigned would stop them all
+	client.StopContainerFunc = func(id string, timeout uint) error {
+		t.Errorf("RegisterApp() stopped %s", id)
+		return nil
+	}
+
+	registrations, err := s.RegisterApp("dev", "web", "10.0.0.1", "web")
+	if err != nil {
+		t.Fatalf("RegisterApp() = %v, want %v", err, nil)
+	}
+
+	registered := []string{}
+	for _, r := range registrations {
+		registered = append(registered, r.Name+" "+r.ContainerID)
+	}
+
+	want := []string{"web web_container_0", "web web_container_1"}
+	if !reflect.DeepEqual(registered, want) {
+		t.Errorf("RegisterApp() = %v, want %v", registered, want)
+	}
+}
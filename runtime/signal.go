@@ -0,0 +1,32 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/log"
+)
+
+// SignalApp sends sig to an instance of app on this host, e.g. SIGHUP to have
+// it reload its config in place. Env changes don't alter the image, so Start
+// won't recreate the container for them.
+func (s *ServiceRuntime) SignalApp(app string, instance int, sig os.Signal) error {
+	// docker takes the signal number, which only syscall signals have
+	num, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("can't send %s to %s instance %d", sig, app, instance)
+	}
+
+	container, err := s.findInstance(app, instance)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sending %s to %s instance %d", sig, app, instance)
+	return s.dockerClient.KillContainer(docker.KillContainerOptions{
+		ID:     container.ID,
+		Signal: docker.Signal(num),
+	})
+}
@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSignalApp(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+	client.addContainer("bbb", "web", "web:1", 1)
+	client.addContainer("ccc", "api", "api:1", 1)
+
+	var killed []docker.KillContainerOptions
+	client.KillContainerFunc = func(opts docker.KillContainerOptions) error {
+		killed = append(killed, opts)
+		return nil
+	}
+
+	if err := s.SignalApp("web", 1, syscall.SIGHUP); err != nil {
+		t.Fatalf("SignalApp() = %v, want %v", err, nil)
+	}
+
+	want := docker.KillContainerOptions{ID: "bbb", Signal: docker.Signal(syscall.SIGHUP)}
+	if len(killed) != 1 || killed[0] != want {
+		t.Errorf("SignalApp() sent %+v, want %+v", killed, want)
+	}
+}
+
+func TestSignalAppNoContainer(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	client.KillContainerFunc = func(opts docker.KillContainerOptions) error {
+		t.Errorf("KillContainer() called for a missing container")
+		return nil
+	}
+
+	if err := s.SignalApp("web", 2, syscall.SIGHUP); err == nil {
+		t.Errorf("SignalApp() = %v, want an error", err)
+	}
+
+	if err := s.SignalApp("api", 0, syscall.SIGHUP); err == nil {
+		t.Errorf("SignalApp() = %v, want an error", err)
+	}
+}
+
+type fakeSignal struct{}
+
+func (f fakeSignal) String() string { return "fake" }
+func (f fakeSignal) Signal()        {}
+
+func TestSignalAppUnknownSignal(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("aaa", "web", "web:1", 0)
+
+	var sig os.Signal = fakeSignal{}
+	if err := s.SignalApp("web", 0, sig); err == nil {
+		t.Errorf("SignalApp(%s) = %v, want an error", sig, err)
+	}
+}
@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/litl/galaxy/utils"
+)
+
+// slotReservations holds the instance slots handed out to containers that are
+// still being created. They aren't running yet, so the slot counts can't see
+// them, and a concurrent Start would pick the same slot.
+type slotReservations struct {
+	sync.Mutex
+	// reserved slots by app and version
+	slots map[string]map[int]bool
+	// serializes reservations for the same app and version, without making
+	// other apps wait on their docker calls
+	keys map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	// reservations holding or waiting on the lock
+	refs int
+}
+
+func slotKey(app, versionId string) string {
+	return app + "/" + versionId
+}
+
+// lockKey locks the reservations for key, and returns the func that unlocks
+// them.
+func (r *slotReservations) lockKey(key string) func() {
+	r.Lock()
+	if r.keys == nil {
+		r.keys = make(map[string]*keyLock)
+	}
+	l := r.keys[key]
+	if l == nil {
+		l = &keyLock{}
+		r.keys[key] = l
+	}
+	l.refs++
+	r.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		r.Lock()
+		defer r.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(r.keys, key)
+		}
+	}
+}
+
+// withReserved adds the slots reserved for app and versionId to used. The
+// caller holds the lock.
+func (r *slotReservations) withReserved(app, versionId string, used []int) []int {
+	all := append([]int{}, used...)
+	for slot := range r.slots[slotKey(app, versionId)] {
+		all = append(all, slot)
+	}
+	sort.Ints(all)
+	return all
+}
+
+// reserveInstanceSlot returns the next free instance slot for app and
+// versionId, the same as NextInstanceSlot, and holds it until release is
+// called. The slot should be released once its container is running, or
+// failed to start.
+func (s *ServiceRuntime) reserveInstanceSlot(app, versionId string) (int, func(), error) {
+	key := slotKey(app, versionId)
+
+	// listing the slots in use is part of the reservation, or a container
+	// that starts and releases its slot in between would be missed. Only
+	// reservations for the same app and version wait on it.
+	unlock := s.reserved.lockKey(key)
+	defer unlock()
+
+	used, _, err := s.InstanceSlots(app, versionId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.reserved.Lock()
+	slot := utils.NextSlot(s.reserved.withReserved(app, versionId, used))
+	if s.reserved.slots == nil {
+		s.reserved.slots = make(map[string]map[int]bool)
+	}
+	if s.reserved.slots[key] == nil {
+		s.reserved.slots[key] = make(map[int]bool)
+	}
+	s.reserved.slots[key][slot] = true
+	s.reserved.Unlock()
+
+	release := func() {
+		unlock := s.reserved.lockKey(key)
+		defer unlock()
+
+		s.reserved.Lock()
+		defer s.reserved.Unlock()
+		delete(s.reserved.slots[key], slot)
+		if len(s.reserved.slots[key]) == 0 {
+			delete(s.reserved.slots, key)
+		}
+	}
+	return slot, release, nil
+}
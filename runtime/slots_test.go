@@ -0,0 +1,160 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/litl/galaxy/config"
+)
+
+func TestStartReservesInstanceSlots(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	const starts = 4
+
+	// hold every create until all of them are in flight, so none of the
+	// containers is running yet when the others pick their slots
+	var mu sync.Mutex
+	instances := []string{}
+	inFlight := make(chan struct{})
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		mu.Lock()
+		instances = append(instances, s.EnvFor(&docker.Container{Config: opts.Config})["GALAXY_INSTANCE"])
+		if len(instances) == starts {
+			close(inFlight)
+		}
+		id := fmt.Sprintf("%064d", len(instances))
+		mu.Unlock()
+
+		select {
+		case <-inFlight:
+		case <-time.After(time.Second):
+			return nil, errors.New("timed out waiting for the other starts")
+		}
+		return &docker.Container{ID: id, Config: opts.Config}, nil
+	}
+	client.StartContainerFunc = func(id string, hostConfig *docker.HostConfig) error {
+		return nil
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, starts)
+	for i := 0; i < starts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Start("dev", "web", appCfg); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Start() = %v, want %v", err, nil)
+	}
+
+	sort.Strings(instances)
+	want := []string{"0", "1", "2", "3"}
+	if fmt.Sprint(instances) != fmt.Sprint(want) {
+		t.Errorf("Start() instances = %v, want %v", instances, want)
+	}
+
+	// the reservations are gone once the starts return
+	if next, err := s.NextInstanceSlot("web", strconv.FormatInt(appCfg.ID(), 10)); next != 0 || err != nil {
+		t.Errorf("NextInstanceSlot() = %d, %v, want %d, %v", next, err, 0, nil)
+	}
+}
+
+func TestStartReleasesSlotOnFailure(t *testing.T) {
+	s, client := newTestRuntime()
+	client.images = append(client.images, &docker.Image{ID: "web:1"})
+
+	createErr := errors.New("no space left on device")
+	client.CreateContainerFunc = func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+		return nil, createErr
+	}
+
+	appCfg := config.NewAppConfig("web", "web:1")
+	if _, err := s.Start("dev", "web", appCfg); err != createErr {
+		t.Fatalf("Start() = %v, want %v", err, createErr)
+	}
+
+	if len(s.reserved.slots) != 0 {
+		t.Errorf("Start() left slots reserved: %v", s.reserved.slots)
+	}
+}
+
+func TestNextInstanceSlotReserved(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_container_0", "web", "1", 0)
+
+	slot, release, err := s.reserveInstanceSlot("web", "1")
+	if slot != 1 || err != nil {
+		t.Fatalf("reserveInstanceSlot() = %d, %v, want %d, %v", slot, err, 1, nil)
+	}
+
+	if next, _ := s.NextInstanceSlot("web", "1"); next != 2 {
+		t.Errorf("NextInstanceSlot() with 1 reserved = %d, want %d", next, 2)
+	}
+
+	// other versions have their own slots
+	if next, _ := s.NextInstanceSlot("web", "2"); next != 0 {
+		t.Errorf("NextInstanceSlot(%q) = %d, want %d", "2", next, 0)
+	}
+
+	release()
+	if next, _ := s.NextInstanceSlot("web", "1"); next != 1 {
+		t.Errorf("NextInstanceSlot() after release = %d, want %d", next, 1)
+	}
+}
+
+func TestReserveInstanceSlotOtherApps(t *testing.T) {
+	s, client := newTestRuntime()
+
+	// the first listing hangs until the test is done, like a slow docker
+	hung := make(chan struct{})
+	defer close(hung)
+	listing := make(chan struct{})
+	var once sync.Once
+	client.ListContainersFunc = func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			close(listing)
+			<-hung
+		}
+		return nil, nil
+	}
+
+	go s.reserveInstanceSlot("web", "1")
+	<-listing
+
+	done := make(chan error, 1)
+	go func() {
+		_, release, err := s.reserveInstanceSlot("api", "1")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("reserveInstanceSlot(%q) = %v, want %v", "api", err, nil)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("reserveInstanceSlot(%q) waited on docker calls for %q", "api", "web")
+	}
+}
@@ -0,0 +1,41 @@
+package runtime
+
+import (
+	"fmt"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerStats returns a snapshot of the CPU, memory and network usage of
+// an instance of app on this host.
+func (s *ServiceRuntime) ContainerStats(app string, instance int) (*docker.Stats, error) {
+	container, err := s.findInstance(app, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	// restarting containers are managed too, but have nothing to measure
+	if !container.State.Running || container.State.Restarting {
+		return nil, fmt.Errorf("%s instance %d isn't running", app, instance)
+	}
+
+	// there's room for the one snapshot, so docker isn't blocked sending it
+	stats := make(chan *docker.Stats, 1)
+	err = s.dockerClient.Stats(docker.StatsOptions{
+		ID:     container.ID,
+		Stats:  stats,
+		Stream: false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case stat, ok := <-stats:
+		if ok && stat != nil {
+			return stat, nil
+		}
+	default:
+	}
+	return nil, fmt.Errorf("no stats returned for %s instance %d", app, instance)
+}
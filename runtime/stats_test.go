@@ -0,0 +1,74 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerStats(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_0_container", "web", "1", 0)
+	client.addContainer("web_1_container", "web", "1", 1)
+
+	canned := &docker.Stats{}
+	canned.MemoryStats.Usage = 64 << 20
+	canned.MemoryStats.Limit = 256 << 20
+	canned.CPUStats.CPUUsage.TotalUsage = 123456
+
+	client.StatsFunc = func(opts docker.StatsOptions) error {
+		if opts.ID != "web_1_container" || opts.Stream {
+			t.Errorf("Stats() = %s stream %t, want %s stream %t", opts.ID, opts.Stream, "web_1_container", false)
+		}
+		opts.Stats <- canned
+		close(opts.Stats)
+		return nil
+	}
+
+	stats, err := s.ContainerStats("web", 1)
+	if err != nil {
+		t.Fatalf("ContainerStats() = %v, want %v", err, nil)
+	}
+
+	if stats != canned {
+		t.Errorf("ContainerStats() = %+v, want %+v", stats, canned)
+	}
+}
+
+func TestContainerStatsNotRunning(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_0_container", "web", "1", 0).State = docker.State{Restarting: true}
+	client.addContainer("web_1_container", "web", "1", 1).State.Running = false
+
+	client.StatsFunc = func(opts docker.StatsOptions) error {
+		t.Errorf("Stats(%s) called for a container that isn't running", opts.ID)
+		return nil
+	}
+
+	for _, instance := range []int{0, 1, 2} {
+		if _, err := s.ContainerStats("web", instance); err == nil {
+			t.Errorf("ContainerStats(%d) = %v, want an error", instance, err)
+		}
+	}
+}
+
+func TestContainerStatsError(t *testing.T) {
+	s, client := newTestRuntime()
+	client.addContainer("web_0_container", "web", "1", 0)
+
+	statsErr := errors.New("connection reset")
+	client.StatsFunc = func(opts docker.StatsOptions) error {
+		return statsErr
+	}
+
+	if _, err := s.ContainerStats("web", 0); err != statsErr {
+		t.Errorf("ContainerStats() = %v, want %v", err, statsErr)
+	}
+
+	// no error, but no stats either
+	client.StatsFunc = nil
+	if _, err := s.ContainerStats("web", 0); err == nil {
+		t.Errorf("ContainerStats() without stats = %v, want an error", err)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes retry delays that double from Min up to Max. Each delay is
+// jittered down by up to half, so clients retrying at the same time spread
+// out. A Backoff is not safe for concurrent use.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt uint
+	rand    *rand.Rand
+}
+
+// Next returns the delay before the next retry, and increases the one after.
+func (b *Backoff) Next() time.Duration {
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	delay := b.Max
+	// compare against Max shifted down, so doubling Min can't overflow
+	if b.attempt < 63 && b.Min <= b.Max>>b.attempt {
+		delay = b.Min << b.attempt
+		b.attempt++
+	}
+
+	half := int64(delay / 2)
+	if half == 0 {
+		return delay
+	}
+	return time.Duration(half + b.rand.Int63n(half+1))
+}
+
+// Reset starts the delays back at Min.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrows(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 60 * time.Second}
+
+	for _, max := range []time.Duration{1, 2, 4, 8, 16, 32, 60, 60, 60} {
+		max *= time.Second
+		delay := b.Next()
+		if delay < max/2 || delay > max {
+			t.Errorf("Next() = %s, want between %s and %s", delay, max/2, max)
+		}
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 60 * time.Second}
+	for i := 0; i < 10; i++ {
+		b.Next()
+	}
+
+	b.Reset()
+	if delay := b.Next(); delay < time.Second/2 || delay > time.Second {
+		t.Errorf("Next() after Reset() = %s, want between %s and %s", delay, time.Second/2, time.Second)
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: time.Duration(1<<63 - 1)}
+	for i := 0; i < 100; i++ {
+		if delay := b.Next(); delay <= 0 {
+			t.Fatalf("Next() = %s after %d retries, want > 0", delay, i)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"bufio"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -55,25 +58,42 @@ func HumanDuration(d time.Duration) string {
 	return fmt.Sprintf("%f years", d.Hours()/24/365)
 }
 
-func SplitDockerImage(img string) (string, string, string) {
-	index := 0
+var digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
+
+// SplitDockerImage splits an image reference into its registry, repository,
+// tag and digest. The registry is the first part of a name with a slash, which
+// is the user for an index image like litl/web, and may have a port like
+// registry:5000/web. The tag defaults to latest unless the reference has a
+// digest, like ubuntu@sha256:abc..., in which case it's only set if given.
+func SplitDockerImage(img string) (string, string, string, string) {
+	var registry, tag, digest string
+	if separator := strings.Index(img, "@"); separator >= 0 {
+		digest = img[separator+1:]
+		img = img[:separator]
+	}
+
+	// a colon after the last slash starts the tag, one before it is a port
+	if separator := strings.LastIndex(img, ":"); separator > strings.LastIndex(img, "/") {
+		tag = img[separator+1:]
+		img = img[:separator]
+	}
+
 	repository := img
-	var registry, tag string
-	if strings.Contains(img, "/") {
-		separator := strings.Index(img, "/")
-		registry = img[index:separator]
-		index = separator + 1
-		repository = img[index:]
+	if separator := strings.Index(img, "/"); separator >= 0 {
+		registry = img[:separator]
+		repository = img[separator+1:]
 	}
 
-	if strings.Contains(img, ":") {
-		separator := strings.Index(img, ":")
-		repository = img[index:separator]
-		index = separator + 1
-		tag = img[index:]
+	if tag == "" && digest == "" {
+		tag = "latest"
 	}
+	return registry, repository, tag, digest
+}
 
-	return registry, repository, tag
+// IsDigest returns true if ref is a content digest, like sha256:abc..., rather
+// than a tag.
+func IsDigest(ref string) bool {
+	return digestRegexp.MatchString(ref)
 }
 
 func StringInSlice(a string, list []string) bool {
@@ -128,7 +148,8 @@ func GalaxyRedisHost(c *cli.Context) string {
 	return strings.TrimSpace(GetEnv("GALAXY_REGISTRY_URL", ""))
 }
 
-// NextSlot finds the first available index in an array of integers
+// NextSlot finds the first available index in an array of integers, i.e. the
+// lowest non-negative number not in used. Gaps are filled before the end.
 func NextSlot(used []int) int {
 	free := 0
 RESTART:
@@ -141,34 +162,89 @@ RESTART:
 	return free
 }
 
+// ParseMemory parses a memory size of the form <number><optional unit>, where
+// unit is b, k, m or g in either case, into a number of bytes. An empty size is
+// 0.
 func ParseMemory(mem string) (int64, error) {
 	if mem == "" {
 		return 0, nil
 	}
 
+	num := strings.ToLower(mem)
+	num = strings.TrimSuffix(num, "b")
+
 	multiplier := int64(1)
-	if strings.HasSuffix(mem, "b") {
-		mem = mem[:len(mem)-1]
+	for i, unit := range []string{"k", "m", "g"} {
+		if strings.HasSuffix(num, unit) {
+			multiplier = int64(1) << (10 * uint(i+1))
+			num = num[:len(num)-1]
+			break
+		}
 	}
 
-	if strings.HasSuffix(mem, "k") {
-		multiplier = int64(1024)
-		mem = mem[:len(mem)-1]
+	i, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || i < 0 || i > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid memory size %q: use <number><optional unit>, where unit = b, k, m or g", mem)
 	}
+	return i * multiplier, nil
+}
 
-	if strings.HasSuffix(mem, "m") {
-		multiplier = int64(1024) * int64(1024)
-		mem = mem[:len(mem)-1]
+// FormatMemory formats a number of bytes for ParseMemory or docker, using the
+// largest unit that represents it exactly.
+func FormatMemory(bytes int64) string {
+	for _, u := range []struct {
+		unit string
+		size int64
+	}{{"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}} {
+		if bytes != 0 && bytes%u.size == 0 {
+			return strconv.FormatInt(bytes/u.size, 10) + u.unit
+		}
 	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// ParseEnvFile reads a docker style env file: one KEY=VALUE per line, with
+// blank lines and lines starting with # ignored. A line with only a KEY takes
+// its value from our environment, and is left out if it isn't set there, the
+// same as docker run --env-file. Errors don't include the line contents,
+// since env files usually hold secrets.
+func ParseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		sep := strings.Index(text, "=")
+		if sep < 0 {
+			if strings.ContainsAny(text, " \t") {
+				return nil, fmt.Errorf("%s:%d: expected KEY=VALUE or KEY", path, line)
+			}
+			if value, ok := os.LookupEnv(text); ok {
+				env[text] = value
+			}
+			continue
+		}
+
+		if sep == 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE or KEY", path, line)
+		}
 
-	if strings.HasSuffix(mem, "g") {
-		multiplier = int64(1024) * int64(1024) * int64(1024)
-		mem = mem[:len(mem)-1]
+		env[strings.TrimSpace(text[:sep])] = text[sep+1:]
 	}
 
-	i, err := strconv.ParseInt(mem, 10, 64)
-	if err != nil {
-		return 0, err
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-	return i * multiplier, nil
+	return env, nil
 }
@@ -1,78 +1,63 @@
 package utils
 
 import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestSplitDockerImageRepository(t *testing.T) {
-	registry, repository, tag := SplitDockerImage("ubuntu")
+func TestSplitDockerImage(t *testing.T) {
+	digest := "sha256:0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2"
 
-	if registry != "" {
-		t.Fail()
-	}
-	if repository != "ubuntu" {
-		t.Fail()
-	}
-	if tag != "" {
-		t.Fail()
-	}
-}
-
-func TestSplitDockerImageWithRegistry(t *testing.T) {
-	registry, repository, tag := SplitDockerImage("custom.registry/ubuntu")
-
-	if registry != "custom.registry" {
-		t.Fail()
-	}
-	if repository != "ubuntu" {
-		t.Fail()
-	}
-	if tag != "" {
-		t.Fail()
-	}
-}
-
-func TestSplitDockerImageWithPublicRegistry(t *testing.T) {
-	registry, repository, tag := SplitDockerImage("username/ubuntu")
-
-	if registry != "username" {
-		t.Fail()
-	}
-	if repository != "ubuntu" {
-		t.Fail()
-	}
-	if tag != "" {
-		t.Fail()
-	}
-}
-
-func TestSplitDockerImageWithRegistryAndTag(t *testing.T) {
-	registry, repository, tag := SplitDockerImage("custom.registry/ubuntu:12.04")
-
-	if registry != "custom.registry" {
-		t.Fail()
-	}
-	if repository != "ubuntu" {
-		t.Fail()
-	}
-	if tag != "12.04" {
-		t.Fail()
+	for _, tc := range []struct {
+		image                             string
+		registry, repository, tag, digest string
+	}{
+		{"ubuntu", "", "ubuntu", "latest", ""},
+		{"ubuntu:12.04", "", "ubuntu", "12.04", ""},
+		{"username/ubuntu", "username", "ubuntu", "latest", ""},
+		{"username/ubuntu:12.04", "username", "ubuntu", "12.04", ""},
+		{"custom.registry/ubuntu", "custom.registry", "ubuntu", "latest", ""},
+		{"custom.registry/ubuntu:12.04", "custom.registry", "ubuntu", "12.04", ""},
+		{"myhost:5000/app", "myhost:5000", "app", "latest", ""},
+		{"myhost:5000/app:tag", "myhost:5000", "app", "tag", ""},
+		{"myhost:5000/team/app:tag", "myhost:5000", "team/app", "tag", ""},
+		{"localhost:5000/app", "localhost:5000", "app", "latest", ""},
+		{"ubuntu@" + digest, "", "ubuntu", "", digest},
+		{"ubuntu:12.04@" + digest, "", "ubuntu", "12.04", digest},
+		{"custom.registry/ubuntu@" + digest, "custom.registry", "ubuntu", "", digest},
+		{"myhost:5000/app@" + digest, "myhost:5000", "app", "", digest},
+	} {
+		registry, repository, tag, digest := SplitDockerImage(tc.image)
+		if registry != tc.registry || repository != tc.repository || tag != tc.tag || digest != tc.digest {
+			t.Errorf("SplitDockerImage(%q) = %q, %q, %q, %q, want %q, %q, %q, %q", tc.image,
+				registry, repository, tag, digest, tc.registry, tc.repository, tc.tag, tc.digest)
+		}
 	}
 }
 
-func TestSplitDockerImageWithRepositoryAndTag(t *testing.T) {
-	registry, repository, tag := SplitDockerImage("ubuntu:12.04")
-
-	if registry != "" {
-		t.Fail()
+func TestIsDigest(t *testing.T) {
+	for _, ref := range []string{
+		"sha256:0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2",
+		"sha512:0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2",
+	} {
+		if !IsDigest(ref) {
+			t.Errorf("IsDigest(%q) = false, want true", ref)
+		}
 	}
 
-	if repository != "ubuntu" {
-		t.Fail()
-	}
-
-	if tag != "12.04" {
-		t.Fail()
+	for _, ref := range []string{
+		"",
+		"latest",
+		"12.04",
+		"ubuntu:12.04",
+		"0d7e4a2bd8e9d9a0b5b8e3c0f0e1c0ab41c07e5a69d73c8b374e0fd3a61ab4e2",
+	} {
+		if IsDigest(ref) {
+			t.Errorf("IsDigest(%q) = true, want false", ref)
+		}
 	}
 }
 
@@ -94,6 +79,12 @@ func TestNextSlotGap(t *testing.T) {
 	}
 }
 
+func TestNextSlotUnsorted(t *testing.T) {
+	if NextSlot([]int{3, 0, 1}) != 2 {
+		t.Fatal("Expected 2")
+	}
+}
+
 func TestNextSlotEnd(t *testing.T) {
 	if NextSlot([]int{0, 1, 2, 3}) != 4 {
 		t.Fatal("Expected 4")
@@ -167,3 +158,135 @@ func TestParseMemGigSuffix(t *testing.T) {
 		t.Fatal("Expected 4294967296")
 	}
 }
+
+func TestParseMemUnits(t *testing.T) {
+	for _, test := range []struct {
+		mem   string
+		bytes int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512b", 512},
+		{"512B", 512},
+		{"1k", 1024},
+		{"1K", 1024},
+		{"1kb", 1024},
+		{"1024m", 1 << 30},
+		{"1g", 1 << 30},
+		{"1G", 1 << 30},
+		{"1GB", 1 << 30},
+		{"8589934591g", 8589934591 << 30}, // the most that fits in an int64
+	} {
+		bytes, err := ParseMemory(test.mem)
+		if bytes != test.bytes || err != nil {
+			t.Errorf("ParseMemory(%q) = %d, %v, want %d, %v", test.mem, bytes, err, test.bytes, nil)
+		}
+	}
+}
+
+func TestParseMemInvalid(t *testing.T) {
+	for _, mem := range []string{"g", "b", "1t", "1 g", " 1g", "1.5g", "-1", "-1m", "1gg", "1bk", "8589934592g"} {
+		if bytes, err := ParseMemory(mem); err == nil {
+			t.Errorf("ParseMemory(%q) = %d, %v, want an error", mem, bytes, err)
+		}
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	for bytes, want := range map[int64]string{
+		0:             "0",
+		512:           "512",
+		1024:          "1k",
+		1536:          "1536",
+		3 << 20:       "3m",
+		1 << 30:       "1g",
+		1<<30 + 1<<20: "1025m",
+	} {
+		if got := FormatMemory(bytes); got != want {
+			t.Errorf("FormatMemory(%d) = %q, want %q", bytes, got, want)
+		}
+
+		if parsed, _ := ParseMemory(want); parsed != bytes {
+			t.Errorf("ParseMemory(FormatMemory(%d)) = %d", bytes, parsed)
+		}
+	}
+}
+
+func writeTempFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "galaxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestParseEnvFile(t *testing.T) {
+	path := writeTempFile(t, "# secrets\nDATABASE_URL=postgres://u:p@db/app?a=b\n\n  API_KEY = abc \nEMPTY=\n")
+	defer os.Remove(path)
+
+	env, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"DATABASE_URL": "postgres://u:p@db/app?a=b",
+		"API_KEY":      " abc",
+		"EMPTY":        "",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("Expected %v. Got %v", expected, env)
+	}
+}
+
+func TestParseEnvFileInherit(t *testing.T) {
+	os.Setenv("GALAXY_TEST_INHERITED", "from env")
+	defer os.Unsetenv("GALAXY_TEST_INHERITED")
+	os.Unsetenv("GALAXY_TEST_UNSET")
+
+	path := writeTempFile(t, "GOOD=1\nGALAXY_TEST_INHERITED\n  GALAXY_TEST_UNSET\n")
+	defer os.Remove(path)
+
+	env, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"GOOD":                  "1",
+		"GALAXY_TEST_INHERITED": "from env",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("Expected %v. Got %v", expected, env)
+	}
+}
+
+func TestParseEnvFileBadLine(t *testing.T) {
+	for _, contents := range []string{
+		"GOOD=1\nsecret without key\n",
+		"GOOD=1\n=secret\n",
+	} {
+		path := writeTempFile(t, contents)
+		defer os.Remove(path)
+
+		_, err := ParseEnvFile(path)
+		if err == nil {
+			t.Fatalf("Expected an error for %q", contents)
+		}
+
+		if strings.Contains(err.Error(), "secret") {
+			t.Fatalf("Expected the error not to include the line. Got %s", err)
+		}
+	}
+}
+
+func TestParseEnvFileMissing(t *testing.T) {
+	if _, err := ParseEnvFile("/nonexistent/galaxy.env"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -9,20 +10,51 @@ import (
 // The value of the key is the value with the latest version.  VersionMaps can be combined
 // such that they always converge to the same values for all keys.
 type VersionedMap struct {
-	values map[string][]mapEntry
+	values   map[string][]MapEntry
+	resolver ConflictResolver
 }
 
-type mapEntry struct {
+// MapEntry is one value of a key, set at version. An unset has an empty
+// value.
+type MapEntry struct {
 	value   string
 	version int64
 }
 
+func (e MapEntry) Value() string {
+	return e.value
+}
+
+func (e MapEntry) Version() int64 {
+	return e.version
+}
+
+// ConflictResolver picks the winner of two entries with the same version. It
+// must give the same answer whichever order the entries are passed in, or
+// maps won't converge.
+type ConflictResolver func(a, b MapEntry) MapEntry
+
+// DefaultConflictResolver prefers setting a value over unsetting one, and the
+// largest value as a tie-breaker if two sets conflict.
+func DefaultConflictResolver(a, b MapEntry) MapEntry {
+	if b.value > a.value {
+		return b
+	}
+	return a
+}
+
 func NewVersionedMap() *VersionedMap {
 	return &VersionedMap{
-		values: make(map[string][]mapEntry),
+		values: make(map[string][]MapEntry),
 	}
 }
 
+// SetConflictResolver sets how Get picks between entries with the same
+// version. The DefaultConflictResolver is used if fn is nil.
+func (v *VersionedMap) SetConflictResolver(fn ConflictResolver) {
+	v.resolver = fn
+}
+
 func (v *VersionedMap) currentVersion(key string) int64 {
 	next := int64(0)
 	for _, mapEntry := range v.values[key] {
@@ -39,7 +71,7 @@ func (v *VersionedMap) nextVersion(key string) int64 {
 
 func (v *VersionedMap) SetVersion(key, value string, version int64) {
 	entries := v.values[key]
-	v.values[key] = append(entries, mapEntry{
+	v.values[key] = append(entries, MapEntry{
 		value:   value,
 		version: version,
 	})
@@ -47,7 +79,7 @@ func (v *VersionedMap) SetVersion(key, value string, version int64) {
 
 func (v *VersionedMap) UnSetVersion(key string, version int64) {
 	entries := v.values[key]
-	v.values[key] = append(entries, mapEntry{
+	v.values[key] = append(entries, MapEntry{
 		value:   "",
 		version: version,
 	})
@@ -62,18 +94,32 @@ func (v *VersionedMap) UnSet(key string) {
 }
 
 func (v *VersionedMap) Get(key string) string {
+	return v.GetVersion(key, 0)
+}
+
+// GetVersion returns the value key had at version, ignoring any later
+// entries. A version of 0 returns the current value.
+func (v *VersionedMap) GetVersion(key string, version int64) string {
+	resolve := v.resolver
+	if resolve == nil {
+		resolve = DefaultConflictResolver
+	}
+
 	entries := v.values[key]
-	maxEntry := mapEntry{}
+	maxEntry := MapEntry{}
 	for _, entry := range entries {
+		if version > 0 && entry.version > version {
+			continue
+		}
+
 		// value is max(version)
 		if entry.version > maxEntry.version {
 			maxEntry = entry
+			continue
 		}
 
-		// if there is a conflict, prefer setting a value over unsetting one
-		// as well the largest value as a tie-breaker if two sets conflict.
-		if entry.version == maxEntry.version && entry.value > maxEntry.value {
-			maxEntry = entry
+		if entry.version == maxEntry.version {
+			maxEntry = resolve(maxEntry, entry)
 		}
 
 	}
@@ -100,10 +146,58 @@ func (v *VersionedMap) LatestVersion() int64 {
 	return latest
 }
 
+// Versions returns every version that has an entry in the map, in increasing
+// order.
+func (v *VersionedMap) Versions() []int64 {
+	seen := make(map[int64]bool)
+	versions := []int64{}
+	for _, entries := range v.values {
+		for _, mapEntry := range entries {
+			if !seen[mapEntry.version] {
+				seen[mapEntry.version] = true
+				versions = append(versions, mapEntry.version)
+			}
+		}
+	}
+	sort.Sort(int64Slice(versions))
+	return versions
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Merge adds the entries of other to the map. Entries the map already has
+// are skipped, so merging the same map again doesn't grow the history.
 func (v *VersionedMap) Merge(other *VersionedMap) {
 	for k, entries := range other.values {
-		v.values[k] = append(v.values[k], entries...)
+		for _, entry := range entries {
+			v.addEntry(k, entry)
+		}
+	}
+}
+
+// MergeNewer merges only the entries of other newer than version since, for
+// syncing from a map whose entries up to since were already merged.
+func (v *VersionedMap) MergeNewer(other *VersionedMap, since int64) {
+	for k, entries := range other.values {
+		for _, entry := range entries {
+			if entry.version > since {
+				v.addEntry(k, entry)
+			}
+		}
+	}
+}
+
+func (v *VersionedMap) addEntry(key string, entry MapEntry) {
+	for _, existing := range v.values[key] {
+		if existing == entry {
+			return
+		}
 	}
+	v.values[key] = append(v.values[key], entry)
 }
 
 func (v *VersionedMap) MarshalMap() map[string]string {
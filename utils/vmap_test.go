@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -21,6 +23,31 @@ func TestSet(t *testing.T) {
 	}
 }
 
+func TestGetVersion(t *testing.T) {
+	vmap := NewVersionedMap()
+	vmap.SetVersion("k1", "v1", 1)
+	vmap.SetVersion("k1", "v2", 3)
+	vmap.UnSetVersion("k1", 5)
+
+	for version, want := range map[int64]string{0: "", 1: "v1", 2: "v1", 3: "v2", 4: "v2", 5: "", 6: ""} {
+		if got := vmap.GetVersion("k1", version); got != want {
+			t.Errorf("GetVersion(%q, %d) = %q, want %q", "k1", version, got, want)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	vmap := NewVersionedMap()
+	vmap.SetVersion("k1", "v1", 3)
+	vmap.SetVersion("k2", "v1", 1)
+	vmap.SetVersion("k2", "v2", 3)
+	vmap.UnSetVersion("k1", 7)
+
+	if versions := vmap.Versions(); !reflect.DeepEqual(versions, []int64{1, 3, 7}) {
+		t.Errorf("Versions() = %v, want %v", versions, []int64{1, 3, 7})
+	}
+}
+
 func TestMerge(t *testing.T) {
 	vmap1 := NewVersionedMap()
 	vmap1.Set("k1", "v1")
@@ -39,6 +66,61 @@ func TestMerge(t *testing.T) {
 	}
 }
 
+func TestMergeIdempotent(t *testing.T) {
+	src := NewVersionedMap()
+	src.SetVersion("k1", "v1", 1)
+	src.SetVersion("k1", "v2", 2)
+	src.UnSetVersion("k2", 3)
+
+	vmap := NewVersionedMap()
+	vmap.SetVersion("k1", "v1", 1)
+	vmap.SetVersion("k1", "other", 2)
+
+	vmap.Merge(src)
+	merged := len(vmap.values["k1"]) + len(vmap.values["k2"])
+
+	vmap.Merge(src)
+	vmap.Merge(src)
+	if again := len(vmap.values["k1"]) + len(vmap.values["k2"]); again != merged {
+		t.Errorf("Merge() again = %d entries, want %d", again, merged)
+	}
+
+	// a conflicting value at the same version isn't a duplicate
+	if merged != 4 {
+		t.Errorf("Merge() = %d entries, want %d", merged, 4)
+	}
+
+	if vmap.Get("k1") != "v2" || vmap.Get("k2") != "" {
+		t.Errorf("Merge() = k1 %q k2 %q, want %q %q", vmap.Get("k1"), vmap.Get("k2"), "v2", "")
+	}
+}
+
+func TestMergeNewer(t *testing.T) {
+	src := NewVersionedMap()
+	src.SetVersion("k1", "v1", 1)
+	src.SetVersion("k1", "v2", 2)
+	src.SetVersion("k2", "v1", 2)
+	src.SetVersion("k1", "v3", 3)
+	src.UnSetVersion("k2", 4)
+
+	vmap := NewVersionedMap()
+	vmap.MergeNewer(src, 2)
+
+	want := map[string][]MapEntry{
+		"k1": {{value: "v3", version: 3}},
+		"k2": {{value: "", version: 4}},
+	}
+	if !reflect.DeepEqual(vmap.values, want) {
+		t.Errorf("MergeNewer(2) = %v, want %v", vmap.values, want)
+	}
+
+	// nothing is newer than the latest version
+	vmap.MergeNewer(src, src.LatestVersion())
+	if !reflect.DeepEqual(vmap.values, want) {
+		t.Errorf("MergeNewer(%d) = %v, want %v", src.LatestVersion(), vmap.values, want)
+	}
+}
+
 func TestUnset(t *testing.T) {
 	vmap := NewVersionedMap()
 	vmap.Set("k1", "v1")
@@ -206,3 +288,61 @@ func TestMarshalExpiredWithAge(t *testing.T) {
 		t.Fatalf("Expected value not found. Got %#v", old)
 	}
 }
+
+// numericMax picks the larger number, and a number over anything else
+func numericMax(a, b MapEntry) MapEntry {
+	an, aErr := strconv.ParseInt(a.Value(), 10, 64)
+	bn, bErr := strconv.ParseInt(b.Value(), 10, 64)
+	switch {
+	case aErr != nil && bErr != nil:
+		return DefaultConflictResolver(a, b)
+	case bErr != nil:
+		return a
+	case aErr != nil || bn > an:
+		return b
+	}
+	return a
+}
+
+func TestConflictResolver(t *testing.T) {
+	for _, tc := range []struct {
+		values        []string
+		want, numeric string
+	}{
+		{[]string{"9", "10"}, "9", "10"},
+		{[]string{"10", "9"}, "9", "10"},
+		{[]string{"", "3"}, "3", "3"},
+		{[]string{"b", "a"}, "b", "b"},
+		{[]string{"7", "x", "12"}, "x", "12"},
+	} {
+		vmap := NewVersionedMap()
+		vmap.Set("k1", "old")
+		for _, value := range tc.values {
+			if value == "" {
+				vmap.UnSetVersion("k1", 2)
+			} else {
+				vmap.SetVersion("k1", value, 2)
+			}
+		}
+
+		if got := vmap.Get("k1"); got != tc.want {
+			t.Errorf("Get() with %q = %q, want %q", tc.values, got, tc.want)
+		}
+
+		vmap.SetConflictResolver(numericMax)
+		if got := vmap.Get("k1"); got != tc.numeric {
+			t.Errorf("Get() with %q and numericMax = %q, want %q", tc.values, got, tc.numeric)
+		}
+
+		// the resolver only breaks ties
+		vmap.SetVersion("k1", "1", 3)
+		if got := vmap.Get("k1"); got != "1" {
+			t.Errorf("Get() after a newer set = %q, want %q", got, "1")
+		}
+
+		vmap.SetConflictResolver(nil)
+		if got := vmap.GetVersion("k1", 2); got != tc.want {
+			t.Errorf("GetVersion(2) with %q after reset = %q, want %q", tc.values, got, tc.want)
+		}
+	}
+}